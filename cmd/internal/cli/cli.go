@@ -0,0 +1,33 @@
+// Package cli holds what the client and server binaries share around their
+// REPLs: middleware, metrics and tracing setup.
+package cli
+
+import (
+    "fmt"
+    "net/http"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+    amqp "github.com/rabbitmq/amqp091-go"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// How many handled message IDs a subscription remembers to skip duplicates.
+const DedupeCapacity = 10000
+
+// PrintPrompt reprints the REPL prompt after a handler's output.
+func PrintPrompt(next pubsub.Handler) pubsub.Handler {
+    return func(message amqp.Delivery) pubsub.AckType {
+        defer fmt.Print("> ")
+        return next(message)
+    }
+}
+
+// ServeMetrics serves Prometheus metrics at addr/metrics in the background.
+func ServeMetrics(addr string) {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", promhttp.Handler())
+    go func() {
+        if err := http.ListenAndServe(addr, mux); err != nil {
+            fmt.Printf("Metrics server stopped: %v\n", err)
+        }
+    }()
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+    "context"
+    "os"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// SetupTracing appends spans to path as JSON. Without a path, trace context
+// is still passed along but no spans are recorded. The returned function
+// flushes any spans not yet written.
+func SetupTracing(path, serviceName string) (func(), error) {
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+    if path == "" { return func() {}, nil }
+
+    file, err := os.OpenFile(path, os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0644)
+    if err != nil { return nil, err }
+    exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+    )
+    otel.SetTracerProvider(provider)
+    return func() {
+        provider.Shutdown(context.Background())
+        file.Close()
+    }, nil
+}
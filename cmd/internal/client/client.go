@@ -0,0 +1,113 @@
+// Package client holds the client's message handlers, so they can run without
+// the REPL around them.
+package client
+
+import (
+    "context"
+    "fmt"
+    "time"
+    "github.com/bootdotdev/learn-pub-sub-starter/cmd/internal/routes"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// How long to wait for the server to send the scenario.
+const ScenarioTimeout = 10 * time.Second
+
+// SaveAfter records the player's units in the outbox after every message,
+// since handling one may have changed them.
+func SaveAfter(gs *gamelogic.GameState, outbox *pubsub.Outbox) pubsub.Middleware {
+    return func(next pubsub.Handler) pubsub.Handler {
+        return func(message amqp.Delivery) pubsub.AckType {
+            ack := next(message)
+            if err := outbox.Commit(gs.GetPlayerSnap()); err != nil {
+                fmt.Printf("Failed to save units: %v\n", err)
+            }
+            return ack
+        }
+    }
+}
+
+type PauseHandler = func(routing.PlayingState) pubsub.AckType
+func HandlerPause(gs *gamelogic.GameState) PauseHandler {
+    return func(ps routing.PlayingState) pubsub.AckType {
+        gs.HandlePause(ps)
+        return pubsub.AckTypeAck
+    }
+}
+
+type MoveHandler = func(pubsub.Delivery[gamelogic.ArmyMove]) pubsub.AckType
+func HandlerMove(gs *gamelogic.GameState) MoveHandler {
+    return func(delivery pubsub.Delivery[gamelogic.ArmyMove]) pubsub.AckType {
+        switch gs.HandleMove(delivery.Body) {
+        // The server fights any war the move starts, and sends every move to
+        // its own player too.
+        case gamelogic.MoveOutcomeSamePlayer, gamelogic.MoveOutComeSafe, gamelogic.MoveOutcomeMakeWar: return pubsub.AckTypeAck
+        default: return pubsub.AckTypeNackDiscard
+        }
+    }
+}
+
+type StateHandler = func(gamelogic.Player) pubsub.AckType
+func HandlerState(gs *gamelogic.GameState) StateHandler {
+    return func(player gamelogic.Player) pubsub.AckType {
+        // Every player's state is broadcast, only ours concerns us.
+        if player.Username != gs.GetUsername() { return pubsub.AckTypeAck }
+        gs.HandleState(player)
+        return pubsub.AckTypeAck
+    }
+}
+
+type RejectionHandler = func(gamelogic.IntentRejection) pubsub.AckType
+func HandlerRejection() RejectionHandler {
+    return func(rejection gamelogic.IntentRejection) pubsub.AckType {
+        gamelogic.HandleRejection(rejection)
+        return pubsub.AckTypeAck
+    }
+}
+
+// SendIntent queues an intent for the server in the outbox.
+func SendIntent(outbox *pubsub.Outbox, gs *gamelogic.GameState, intent gamelogic.Intent) error {
+    intent.Username = gs.GetUsername()
+    message, err := routing.OutboxMessage(routes.Intents, intent.Username, intent)
+    if err != nil { return err }
+    return outbox.Commit(gs.GetPlayerSnap(), message)
+}
+
+// The server fights wars and sends both sides the result.
+type WarResultHandler = func(gamelogic.WarResult) pubsub.AckType
+func HandlerWarResult(gs *gamelogic.GameState) WarResultHandler {
+    return func(result gamelogic.WarResult) pubsub.AckType {
+        gs.HandleWarResult(result)
+        return pubsub.AckTypeAck
+    }
+}
+
+func HandlerSnapshot(gs *gamelogic.GameState) func(context.Context, routing.SnapshotRequest) (gamelogic.Player, error) {
+    return func(_ context.Context, req routing.SnapshotRequest) (gamelogic.Player, error) {
+        fmt.Printf("%v asked for a snapshot of your units\n", req.Requester)
+        return gs.GetPlayerSnap(), nil
+    }
+}
+
+// FetchScenario asks the server for the rules of the game being played.
+func FetchScenario(ctx context.Context, subscriber pubsub.Subscriber, publisher pubsub.Publisher, username string) (*gamelogic.Scenario, error) {
+    client, err := pubsub.NewRPCClient(subscriber, publisher, pubsub.CodecJSON)
+    if err != nil { return nil, err }
+    defer client.Close()
+
+    ctx, cancel := context.WithTimeout(ctx, ScenarioTimeout)
+    defer cancel()
+    scenario, err := pubsub.Call[routing.ScenarioRequest, gamelogic.Scenario](
+        ctx,
+        client,
+        routing.ScenarioRoute.Exchange,
+        routing.ScenarioRoute.Key(username),
+        routing.ScenarioRequest { Username: username },
+    )
+    if err != nil { return nil, err }
+    if err := scenario.Validate(); err != nil { return nil, err }
+    return &scenario, nil
+}
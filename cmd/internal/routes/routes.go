@@ -0,0 +1,57 @@
+// Package routes ties the game's messages to the exchanges, codecs and
+// queues the client and server exchange them through.
+package routes
+
+import (
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+var ArmyMoves = routing.Route[gamelogic.ArmyMove] {
+    Exchange: routing.ExchangePerilTopic,
+    Codec: pubsub.JSON[gamelogic.ArmyMove](),
+    Key: routing.ArmyMovesKey,
+    Pattern: routing.Fixed(routing.AllKeys(routing.ArmyMovesPrefix)),
+    Queue: routing.ArmyMovesQueue,
+    QueueType: pubsub.TransientQueue,
+}
+
+var WarResults = routing.Route[gamelogic.WarResult] {
+    Exchange: routing.ExchangePerilTopic,
+    Codec: pubsub.JSON[gamelogic.WarResult](),
+    Key: routing.WarResultKey,
+    Pattern: routing.Fixed(routing.AllKeys(routing.WarResultsPrefix)),
+    Queue: routing.WarResultsQueue,
+    QueueType: pubsub.TransientQueue,
+}
+
+var Intents = routing.Route[gamelogic.Intent] {
+    Exchange: routing.ExchangePerilTopic,
+    Codec: pubsub.JSON[gamelogic.Intent](),
+    Key: routing.IntentKey,
+    Pattern: routing.Fixed(routing.AllKeys(routing.IntentsPrefix)),
+    Queue: routing.Fixed(routing.IntentQueue),
+    QueueType: pubsub.DurableQueue,
+    // Only one server applies intents, so they are checked against one
+    // copy of each player's state.
+    SingleActiveConsumer: true,
+}
+
+var PlayerStates = routing.Route[gamelogic.Player] {
+    Exchange: routing.ExchangePerilTopic,
+    Codec: pubsub.JSON[gamelogic.Player](),
+    Key: routing.PlayerStateKey,
+    Pattern: routing.Fixed(routing.AllKeys(routing.PlayerStatesPrefix)),
+    Queue: routing.PlayerStatesQueue,
+    QueueType: pubsub.TransientQueue,
+}
+
+var IntentRejections = routing.Route[gamelogic.IntentRejection] {
+    Exchange: routing.ExchangePerilDirect,
+    Codec: pubsub.JSON[gamelogic.IntentRejection](),
+    Key: routing.IntentRejectionKey,
+    Pattern: routing.IntentRejectionKey,
+    Queue: routing.IntentRejectionsQueue,
+    QueueType: pubsub.TransientQueue,
+}
@@ -0,0 +1,36 @@
+package routes
+
+import (
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Topology is everything shared by Peril's clients and servers: the
+// exchanges, the dead-letter queue, and the durable game log, intent and
+// scenario request queues.
+func Topology() pubsub.Topology {
+    return pubsub.Topology {
+        Exchanges: []pubsub.ExchangeSpec {
+            { Name: routing.ExchangePerilDirect, Kind: amqp.ExchangeDirect, Durable: true },
+            { Name: routing.ExchangePerilTopic, Kind: amqp.ExchangeTopic, Durable: true },
+        },
+    }.Merge(
+        routing.QueueTopology("", routing.GameLogRoute, Intents, routing.ScenarioRoute),
+        pubsub.DeadLetterTopology(),
+    )
+}
+
+// PlayerTopology is one client's own queues. They are exclusive to the
+// client's connection and go away when it disconnects.
+func PlayerTopology(username string) pubsub.Topology {
+    return routing.QueueTopology(
+        username,
+        routing.PauseRoute,
+        ArmyMoves,
+        routing.SnapshotRoute,
+        WarResults,
+        PlayerStates,
+        IntentRejections,
+    )
+}
@@ -0,0 +1,238 @@
+package server_test
+
+import (
+    "context"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+    "github.com/bootdotdev/learn-pub-sub-starter/cmd/internal/client"
+    "github.com/bootdotdev/learn-pub-sub-starter/cmd/internal/routes"
+    "github.com/bootdotdev/learn-pub-sub-starter/cmd/internal/server"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const testTimeout = 2 * time.Second
+
+func must(t *testing.T, err error) {
+    t.Helper()
+    if err != nil { t.Fatal(err) }
+}
+
+func eventually(t *testing.T, what string, done func() bool) {
+    t.Helper()
+    deadline := time.Now().Add(testTimeout)
+    for !done() {
+        if time.Now().After(deadline) { t.Fatalf("timed out waiting for %v", what) }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
+
+// pricedScenario is the classic scenario with units to pay for, so the
+// tests can see the server keeping accounts.
+func pricedScenario() *gamelogic.Scenario {
+    scenario := *gamelogic.DefaultScenario()
+    costs := map[gamelogic.UnitRank]int { gamelogic.RankInfantry: 1, gamelogic.RankCavalry: 3, gamelogic.RankArtillery: 5 }
+    scenario.Units = append([]gamelogic.UnitType {}, scenario.Units...)
+    for i := range scenario.Units {
+        scenario.Units[i].Cost = costs[scenario.Units[i].Rank]
+    }
+    scenario.Start.Funds = 30
+    return &scenario
+}
+
+// startServer subscribes the server's handlers the way cmd/server does, and
+// sends the game logs it writes to logs. Closing the returned connection
+// stops the server.
+func startServer(t *testing.T, ctx context.Context, broker *pubsub.MemoryBroker, intentLogPath string, logs chan<- routing.GameLog) *pubsub.MemoryConnection {
+    connection := broker.Connect()
+    t.Cleanup(func() { connection.Close() })
+    must(t, routes.Topology().Apply(connection))
+    serverID := "server-" + pubsub.NewMessageID()
+    publisher := pubsub.WithMetadata(connection, routing.AppIDServer, amqp.Table { routing.HeaderServerID: serverID })
+    scenario := pricedScenario()
+    world := gamelogic.NewWorld(scenario)
+    intentLog, err := server.OpenIntentLog(intentLogPath, world)
+    must(t, err)
+
+    writeLogs := func(batch []routing.GameLog) error {
+        for _, gamelog := range batch {
+            logs <- gamelog
+        }
+        return nil
+    }
+    _, err = routing.SubscribeBatch(ctx, connection, routing.GameLogRoute, "", server.HandlerLogs(writeLogs))
+    must(t, err)
+    _, err = routing.SubscribeDelivery(ctx, connection, routes.Intents, "", server.HandlerIntent(world, intentLog, publisher))
+    must(t, err)
+    _, err = routing.Serve(ctx, connection, publisher, routing.ScenarioRoute, serverID, server.HandlerScenario(scenario))
+    must(t, err)
+    return connection
+}
+
+// serverStates collects the states the servers publish for username.
+func serverStates(t *testing.T, ctx context.Context, broker *pubsub.MemoryBroker, username string) func() []gamelogic.Player {
+    connection := broker.Connect()
+    t.Cleanup(func() { connection.Close() })
+    must(t, routing.QueueTopology("spy", routes.PlayerStates).Apply(connection))
+    var mu sync.Mutex
+    states := []gamelogic.Player {}
+    _, err := routing.SubscribeDelivery(ctx, connection, routes.PlayerStates, "spy", func(delivery pubsub.Delivery[gamelogic.Player]) pubsub.AckType {
+        if delivery.AppID == routing.AppIDServer && delivery.Body.Username == username {
+            mu.Lock()
+            states = append(states, delivery.Body)
+            mu.Unlock()
+        }
+        return pubsub.AckTypeAck
+    })
+    must(t, err)
+    return func() []gamelogic.Player {
+        mu.Lock()
+        defer mu.Unlock()
+        return append([]gamelogic.Player {}, states...)
+    }
+}
+
+// startClient joins the game as username the way cmd/client does, and
+// returns the player's state and outbox.
+func startClient(t *testing.T, ctx context.Context, broker *pubsub.MemoryBroker, username string) (*gamelogic.GameState, *pubsub.Outbox) {
+    connection := broker.Connect()
+    t.Cleanup(func() { connection.Close() })
+    must(t, routes.Topology().Merge(routes.PlayerTopology(username)).Apply(connection))
+    publisher := pubsub.WithMetadata(connection, routing.AppIDClient, amqp.Table { routing.HeaderUsername: username })
+    outbox, err := pubsub.OpenOutbox(filepath.Join(t.TempDir(), username + ".outbox.json"), connection)
+    must(t, err)
+
+    gamestate := gamelogic.NewGameState(username)
+    scenario, err := client.FetchScenario(ctx, connection, publisher, username)
+    must(t, err)
+    gamestate.SetScenario(scenario)
+
+    _, err = routing.Subscribe(ctx, connection, routes.PlayerStates, username, client.HandlerState(gamestate), pubsub.WithMiddleware(client.SaveAfter(gamestate, outbox)))
+    must(t, err)
+    _, err = routing.SubscribeDelivery(ctx, connection, routes.ArmyMoves, username, client.HandlerMove(gamestate))
+    must(t, err)
+    _, err = routing.Subscribe(ctx, connection, routes.WarResults, username, client.HandlerWarResult(gamestate), pubsub.WithMiddleware(client.SaveAfter(gamestate, outbox)))
+    must(t, err)
+    must(t, client.SendIntent(outbox, gamestate, gamelogic.Intent { Join: &gamelogic.JoinIntent {} }))
+    go outbox.Run(ctx)
+    // The server's answer to joining brings the starting funds.
+    eventually(t, username + " to join", func() bool { return gamestate.GetPlayerSnap().Funds > 0 })
+    return gamestate, outbox
+}
+
+func spawn(t *testing.T, gamestate *gamelogic.GameState, outbox *pubsub.Outbox, location, rank string) {
+    t.Helper()
+    units := len(gamestate.GetPlayerSnap().Units)
+    intent, err := gamestate.CommandSpawn([]string { "spawn", location, rank })
+    must(t, err)
+    must(t, client.SendIntent(outbox, gamestate, gamelogic.Intent { Spawn: &intent }))
+    eventually(t, gamestate.GetUsername() + "'s " + rank, func() bool { return len(gamestate.GetPlayerSnap().Units) > units })
+}
+
+func TestMoveStartsWarAndLogsIt(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    broker := pubsub.NewMemoryBroker()
+    logs := make(chan routing.GameLog, 10)
+    startServer(t, ctx, broker, filepath.Join(t.TempDir(), "intents.log"), logs)
+    alice, aliceOutbox := startClient(t, ctx, broker, "alice")
+    bob, bobOutbox := startClient(t, ctx, broker, "bob")
+
+    spawn(t, bob, bobOutbox, "asia", "cavalry")
+    spawn(t, alice, aliceOutbox, "europe", "infantry")
+    var infantry gamelogic.Unit
+    for _, unit := range alice.GetPlayerSnap().Units {
+        infantry = unit
+    }
+    move, err := alice.CommandMove([]string { "move", "asia", strconv.Itoa(infantry.ID) })
+    must(t, err)
+    must(t, client.SendIntent(aliceOutbox, alice, gamelogic.Intent { Move: &move }))
+
+    select {
+    case gamelog := <-logs:
+        if gamelog.Username != "alice" || gamelog.Message != "bob won a war against alice" {
+            t.Errorf("logged %v: %q, want alice: %q", gamelog.Username, gamelog.Message, "bob won a war against alice")
+        }
+    case <-time.After(testTimeout):
+        t.Fatal("timed out waiting for the war to be logged")
+    }
+    eventually(t, "alice's infantry to be destroyed", func() bool { return len(alice.GetPlayerSnap().Units) == 0 })
+    if units := bob.GetPlayerSnap().Units; len(units) != 1 {
+        t.Errorf("bob has %v units after winning, want 1", len(units))
+    }
+    var saved gamelogic.Player
+    if ok, err := aliceOutbox.State(&saved); err != nil || !ok || len(saved.Units) != 0 {
+        t.Errorf("alice's saved state has %v units (%v, %v), want none", len(saved.Units), ok, err)
+    }
+    dlq := broker.Connect()
+    defer dlq.Close()
+    consumer, err := dlq.Consume(pubsub.DeadLetterQueue, 0)
+    must(t, err)
+    select {
+    case message := <-consumer.Deliveries():
+        t.Errorf("dead-lettered %v, want nothing", message.RoutingKey)
+    case <-time.After(50 * time.Millisecond):
+    }
+}
+
+func TestServerTrustsOnlyIntentsFromTheirPlayer(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    broker := pubsub.NewMemoryBroker()
+    startServer(t, ctx, broker, filepath.Join(t.TempDir(), "intents.log"), make(chan routing.GameLog, 10))
+    states := serverStates(t, ctx, broker, "alice")
+    alice, aliceOutbox := startClient(t, ctx, broker, "alice")
+
+    // Another connection claims a fortune for alice, and asks for units in
+    // her name.
+    mallory := broker.Connect()
+    defer mallory.Close()
+    rich := alice.GetPlayerSnap()
+    rich.Funds = 99999
+    must(t, routing.Publish(ctx, mallory, routes.PlayerStates, "alice", rich))
+    spawnInfantry := gamelogic.Intent { Username: "alice", Spawn: &gamelogic.SpawnIntent { Location: "europe", Rank: gamelogic.RankInfantry } }
+    must(t, routing.Publish(ctx, mallory, routes.Intents, "mallory", spawnInfantry))
+    impersonating := pubsub.WithMetadata(mallory, routing.AppIDClient, amqp.Table { routing.HeaderUsername: "mallory" })
+    must(t, routing.Publish(ctx, impersonating, routes.Intents, "alice", spawnInfantry))
+
+    intent, err := alice.CommandSpawn([]string { "spawn", "europe", "artillery" })
+    must(t, err)
+    must(t, client.SendIntent(aliceOutbox, alice, gamelogic.Intent { Spawn: &intent }))
+    var spawned gamelogic.Player
+    eventually(t, "alice's artillery", func() bool {
+        for _, state := range states() {
+            if len(state.Units) > 0 { spawned = state }
+        }
+        return len(spawned.Units) > 0
+    })
+    if len(spawned.Units) != 1 || spawned.Units[1].Rank != gamelogic.RankArtillery || spawned.Funds != 25 {
+        t.Errorf("server has alice with %v and %v funds, want only her artillery and 25 funds", spawned.Units, spawned.Funds)
+    }
+}
+
+func TestStandbyServerCatchesUpFromIntentLog(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    broker := pubsub.NewMemoryBroker()
+    intentLogPath := filepath.Join(t.TempDir(), "intents.log")
+    active := startServer(t, ctx, broker, intentLogPath, make(chan routing.GameLog, 10))
+    startServer(t, ctx, broker, intentLogPath, make(chan routing.GameLog, 10))
+    states := serverStates(t, ctx, broker, "alice")
+    alice, aliceOutbox := startClient(t, ctx, broker, "alice")
+    spawn(t, alice, aliceOutbox, "europe", "infantry")
+
+    // The standby takes over the intent queue.
+    eventually(t, "the infantry's state", func() bool { return len(states()) == 2 })
+    must(t, active.Close())
+    spawn(t, alice, aliceOutbox, "asia", "cavalry")
+    eventually(t, "the cavalry's state", func() bool { return len(states()) == 3 })
+    latest := states()[2]
+    if len(latest.Units) != 2 || latest.Funds != 26 || latest.NextUnitID != 3 {
+        t.Errorf("standby has alice with %v, %v funds and next unit %v, want both units, 26 funds and next unit 3", latest.Units, latest.Funds, latest.NextUnitID)
+    }
+}
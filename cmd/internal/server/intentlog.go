@@ -0,0 +1,100 @@
+package server
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sync"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+// One line of the intent log.
+type loggedIntent struct {
+    MessageID string
+    Intent gamelogic.Intent
+}
+
+// IntentLog is a file of the intents the servers of a game have applied, in
+// the order they applied them. Only the server consuming the intent queue
+// writes to it. When another server takes over, it applies the intents it
+// hasn't seen yet before any new one, so it carries on from the same state.
+type IntentLog struct {
+    path string
+
+    mu sync.Mutex
+    offset int64
+    logged map[string]bool
+}
+
+// OpenIntentLog reads the intents logged at path, if any, and applies them to
+// world.
+func OpenIntentLog(path string, world *gamelogic.World) (*IntentLog, error) {
+    log := &IntentLog { path: path, logged: map[string]bool {} }
+    if err := log.CatchUp(world); err != nil { return nil, err }
+    return log, nil
+}
+
+// CatchUp applies to world the intents logged since the last call, by this
+// server or another.
+func (l *IntentLog) CatchUp(world *gamelogic.World) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    f, err := os.Open(l.path)
+    if errors.Is(err, os.ErrNotExist) { return nil }
+    if err != nil { return err }
+    defer f.Close()
+    if _, err := f.Seek(l.offset, io.SeekStart); err != nil { return err }
+    data, err := io.ReadAll(f)
+    if err != nil { return err }
+
+    // A line without its newline is still being written.
+    for {
+        end := bytes.IndexByte(data, '\n')
+        if end < 0 { return nil }
+        var entry loggedIntent
+        if err := json.Unmarshal(data[:end], &entry); err != nil {
+            return fmt.Errorf("intent log %v at offset %v: %w", l.path, l.offset, err)
+        }
+        if !l.logged[entry.MessageID] {
+            l.logged[entry.MessageID] = true
+            if err := world.ReplayIntent(entry.Intent); err != nil {
+                fmt.Printf("Failed to replay intent %v from %v: %v\n", entry.MessageID, entry.Intent.Username, err)
+            }
+        }
+        data = data[end + 1:]
+        l.offset += int64(end + 1)
+    }
+}
+
+// Logged reports whether the intent sent as messageID has been applied
+// already, by a server that stopped before acknowledging it.
+func (l *IntentLog) Logged(messageID string) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.logged[messageID]
+}
+
+// Append records an intent this server applied.
+func (l *IntentLog) Append(messageID string, intent gamelogic.Intent) error {
+    data, err := json.Marshal(loggedIntent { MessageID: messageID, Intent: intent })
+    if err != nil { return err }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    f, err := os.OpenFile(l.path, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644)
+    if err != nil { return err }
+    if _, err := f.Write(append(data, '\n')); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return err
+    }
+    // CatchUp skips the line when it reads it back.
+    l.logged[messageID] = true
+    return f.Close()
+}
@@ -0,0 +1,113 @@
+// Package server holds the server's message handlers, so they can run without
+// the REPL around them.
+package server
+
+import (
+    "context"
+    "fmt"
+    "time"
+    "github.com/bootdotdev/learn-pub-sub-starter/cmd/internal/routes"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// HandlerLogs records batches of game logs with write, usually
+// gamelogic.WriteLogs.
+type LogsHandler = func([]routing.GameLog) pubsub.AckType
+func HandlerLogs(write func([]routing.GameLog) error) LogsHandler {
+    return func(logs []routing.GameLog) pubsub.AckType {
+        if err := write(logs); err != nil {
+            return pubsub.AckTypeNackDiscard
+        }
+        return pubsub.AckTypeAck
+    }
+}
+
+// publishIntentResult announces the changes an accepted intent made. The
+// intent has been applied already, so failing to announce it is only logged:
+// the player's next state update carries the change along.
+func publishIntentResult(ctx context.Context, publisher pubsub.Publisher, result gamelogic.IntentResult) {
+    username := result.Player.Username
+    if err := routing.Publish(ctx, publisher, routes.PlayerStates, username, result.Player); err != nil {
+        fmt.Printf("Failed to publish state of %v: %v\n", username, err)
+    }
+    if result.Move != nil {
+        if err := routing.Publish(ctx, publisher, routes.ArmyMoves, username, *result.Move); err != nil {
+            fmt.Printf("Failed to publish move of %v: %v\n", username, err)
+        }
+    }
+    for _, defender := range result.Defenders {
+        if err := routing.Publish(ctx, publisher, routes.PlayerStates, defender.Username, defender); err != nil {
+            fmt.Printf("Failed to publish state of %v: %v\n", defender.Username, err)
+        }
+    }
+    for _, war := range result.Wars {
+        if err := routing.Publish(ctx, publisher, routes.WarResults, war.Attacker, war); err != nil {
+            fmt.Printf("Failed to publish war between %v and %v: %v\n", war.Attacker, war.Defender, err)
+        }
+        message := fmt.Sprintf("%v won a war against %v", war.Winner, war.Loser)
+        if war.Winner == "" {
+            message = fmt.Sprintf("A war between %v and %v resulted in a draw", war.Attacker, war.Defender)
+        }
+        gamelog := routing.GameLog { CurrentTime: time.Now(), Username: war.Attacker, Message: message }
+        if err := routing.Publish(ctx, publisher, routing.GameLogRoute, war.Attacker, gamelog); err != nil {
+            fmt.Printf("Failed to publish war log: %v\n", err)
+        }
+    }
+}
+
+// HandlerIntent applies intents to world and announces the result, logging
+// each applied intent so a server taking over can catch up.
+//
+// An intent needs a message ID, and is only accepted for the player its
+// routing key names, and the one in its x-peril-username header if it has
+// one. Every client can still
+// publish with any routing key, so telling players apart for certain needs
+// per-player broker credentials, which Peril doesn't have yet.
+type IntentHandler = func(pubsub.Delivery[gamelogic.Intent]) pubsub.AckType
+func HandlerIntent(world *gamelogic.World, log *IntentLog, publisher pubsub.Publisher) IntentHandler {
+    return func(delivery pubsub.Delivery[gamelogic.Intent]) pubsub.AckType {
+        intent := delivery.Body
+        if err := log.CatchUp(world); err != nil {
+            fmt.Printf("Failed to catch up with the intent log: %v\n", err)
+            return pubsub.AckTypeNackRequeue
+        }
+        if log.Logged(delivery.MessageID) { return pubsub.AckTypeAck }
+        username, hasHeader := delivery.Headers[routing.HeaderUsername].(string)
+        if delivery.MessageID == "" || delivery.RoutingKey != routes.Intents.Key(intent.Username) || hasHeader && username != intent.Username {
+            fmt.Printf("Discarded intent %v for %v sent with key %v\n", delivery.MessageID, intent.Username, delivery.RoutingKey)
+            return pubsub.AckTypeNackDiscard
+        }
+
+        result, err := world.ApplyIntent(intent)
+        if err != nil {
+            fmt.Printf("Rejected intent %v from %v: %v\n", delivery.MessageID, intent.Username, err)
+            rejection := gamelogic.IntentRejection { IntentID: delivery.MessageID, Reason: err.Error() }
+            if err := routing.Publish(delivery.Context, publisher, routes.IntentRejections, intent.Username, rejection); err != nil {
+                fmt.Printf("Failed to publish rejection to %v: %v\n", intent.Username, err)
+            }
+            return pubsub.AckTypeAck
+        }
+        if err := log.Append(delivery.MessageID, intent); err != nil {
+            fmt.Printf("Failed to log intent %v, a server taking over won't know of it: %v\n", delivery.MessageID, err)
+        }
+        publishIntentResult(delivery.Context, publisher, result)
+        return pubsub.AckTypeAck
+    }
+}
+
+func HandlerScenario(scenario *gamelogic.Scenario) func(context.Context, routing.ScenarioRequest) (gamelogic.Scenario, error) {
+    return func(_ context.Context, req routing.ScenarioRequest) (gamelogic.Scenario, error) {
+        fmt.Printf("Sending scenario %v to %v\n", scenario.Name, req.Username)
+        return *scenario, nil
+    }
+}
+
+type PauseHandler = func(routing.PlayingState) pubsub.AckType
+func HandlerPause(world *gamelogic.World) PauseHandler {
+    return func(ps routing.PlayingState) pubsub.AckType {
+        world.HandlePause(ps)
+        return pubsub.AckTypeAck
+    }
+}
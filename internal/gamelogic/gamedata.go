@@ -1,22 +1,47 @@
 package gamelogic
 
+import "fmt"
+
 type Player struct {
 	Username string
 	Units    map[int]Unit
+	// NextUnitID is the ID the player's next unit gets. IDs are never
+	// reused, even once their unit is gone.
+	NextUnitID int
+	// Funds pay for spawning units.
+	Funds int
 }
 
 type UnitRank string
 
+// The ranks of the classic scenario. Others can define their own.
 const (
 	RankInfantry  = "infantry"
 	RankCavalry   = "cavalry"
 	RankArtillery = "artillery"
 )
 
+// Unit IDs are only unique among one player's units; the ID and Owner
+// together identify a unit across players.
 type Unit struct {
 	ID       int
 	Rank     UnitRank
 	Location Location
+	Owner    string
+}
+
+// UnitKey identifies a unit across players.
+type UnitKey struct {
+	Owner string
+	ID    int
+}
+
+func (u Unit) Key() UnitKey {
+	return UnitKey{Owner: u.Owner, ID: u.ID}
+}
+
+func (k UnitKey) String() string {
+	return fmt.Sprintf("%s/%d", k.Owner, k.ID)
 }
 
 type ArmyMove struct {
@@ -25,28 +50,17 @@ type ArmyMove struct {
 	ToLocation Location
 }
 
-type RecognitionOfWar struct {
-	Attacker Player
-	Defender Player
+// WarResult is how a war ended. Winner and Loser are empty if it was a draw.
+// Each side's losses are the units it had in Location when the war was
+// fought and no longer has.
+type WarResult struct {
+	Attacker       string
+	Defender       string
+	Winner         string
+	Loser          string
+	Location       Location
+	AttackerLosses []Unit
+	DefenderLosses []Unit
 }
 
 type Location string
-
-func getAllRanks() map[UnitRank]struct{} {
-	return map[UnitRank]struct{}{
-		RankInfantry:  {},
-		RankCavalry:   {},
-		RankArtillery: {},
-	}
-}
-
-func getAllLocations() map[Location]struct{} {
-	return map[Location]struct{}{
-		"americas":   {},
-		"europe":     {},
-		"africa":     {},
-		"asia":       {},
-		"australia":  {},
-		"antarctica": {},
-	}
-}
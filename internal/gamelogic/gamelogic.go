@@ -18,6 +18,7 @@ func PrintClientHelp() {
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
 	fmt.Println("* status")
+	fmt.Println("* map")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -42,6 +43,7 @@ func PrintServerHelp() {
 	fmt.Println("Possible commands:")
 	fmt.Println("* pause")
 	fmt.Println("* resume")
+	fmt.Println("* status <username>")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -85,7 +87,7 @@ func (gs *GameState) CommandStatus() {
 	}
 
 	p := gs.GetPlayerSnap()
-	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
+	fmt.Printf("You are %s, and you have %d units and %d funds.\n", p.Username, len(p.Units), p.Funds)
 	for _, unit := range p.Units {
 		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
 	}
@@ -1,26 +1,71 @@
 package gamelogic
 
 import (
+	"fmt"
 	"sync"
 )
 
 type GameState struct {
-	Player Player
-	Paused bool
-	mu     *sync.RWMutex
+	Player   Player
+	Paused   bool
+	scenario *Scenario
+	mu       *sync.RWMutex
 }
 
+// NewGameState starts a player with nothing, playing DefaultScenario until
+// told otherwise with SetScenario.
 func NewGameState(username string) *GameState {
 	return &GameState{
 		Player: Player{
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Paused:   false,
+		scenario: DefaultScenario(),
+		mu:       &sync.RWMutex{},
 	}
 }
 
+// SetScenario changes the rules the player plays by.
+func (gs *GameState) SetScenario(s *Scenario) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.scenario = s
+}
+
+func (gs *GameState) rules() *Scenario {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.scenario
+}
+
+// startGame gives the player the scenario's starting funds and units.
+func (gs *GameState) startGame() {
+	start := gs.rules().Start
+	gs.mu.Lock()
+	gs.Player.Funds = start.Funds
+	gs.mu.Unlock()
+	for _, unit := range start.Units {
+		gs.addUnit(Unit{
+			ID:       gs.allocateUnitID(),
+			Rank:     unit.Rank,
+			Location: unit.Location,
+			Owner:    gs.GetUsername(),
+		})
+	}
+}
+
+// spend takes cost from the player's funds, unless they don't have enough.
+func (gs *GameState) spend(cost int) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if cost > gs.Player.Funds {
+		return fmt.Errorf("error: that costs %v and you have %v", cost, gs.Player.Funds)
+	}
+	gs.Player.Funds -= cost
+	return nil
+}
+
 func (gs *GameState) resumeGame() {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -45,13 +90,28 @@ func (gs *GameState) addUnit(u Unit) {
 	gs.Player.Units[u.ID] = u
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
+// allocateUnitID hands out the player's next unit ID.
+func (gs *GameState) allocateUnitID() int {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
+	if gs.Player.NextUnitID < 1 {
+		gs.Player.NextUnitID = 1
+	}
+	id := gs.Player.NextUnitID
+	gs.Player.NextUnitID++
+	return id
+}
+
+// removeUnits removes the player's units among units. Other players' units
+// with the same IDs are left alone.
+func (gs *GameState) removeUnits(units []Unit) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for _, u := range units {
+		if u.Owner != "" && u.Owner != gs.Player.Username {
+			continue
 		}
+		delete(gs.Player.Units, u.ID)
 	}
 }
 
@@ -82,6 +142,24 @@ func (gs *GameState) GetUnit(id int) (Unit, bool) {
 	return u, ok
 }
 
+// RestorePlayer replaces the player's units with those of a saved snapshot.
+// Snapshots from before unit IDs were allocated carry no NextUnitID, so it
+// is moved past every restored unit's ID.
+func (gs *GameState) RestorePlayer(p Player) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Player.Units = map[int]Unit{}
+	gs.Player.NextUnitID = p.NextUnitID
+	gs.Player.Funds = p.Funds
+	for k, v := range p.Units {
+		if v.Owner == "" {
+			v.Owner = gs.Player.Username
+		}
+		gs.Player.Units[k] = v
+		gs.Player.NextUnitID = max(gs.Player.NextUnitID, k+1)
+	}
+}
+
 func (gs *GameState) GetPlayerSnap() Player {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
@@ -90,7 +168,9 @@ func (gs *GameState) GetPlayerSnap() Player {
 		Units[k] = v
 	}
 	return Player{
-		Username: gs.Player.Username,
-		Units:    Units,
+		Username:   gs.Player.Username,
+		Units:      Units,
+		NextUnitID: gs.Player.NextUnitID,
+		Funds:      gs.Player.Funds,
 	}
 }
@@ -0,0 +1,51 @@
+package gamelogic
+
+import "fmt"
+
+// JoinIntent asks the server for the player's current state.
+type JoinIntent struct{}
+
+// SpawnIntent asks the server to spawn a unit.
+type SpawnIntent struct {
+	Location Location
+	Rank     UnitRank
+}
+
+// MoveIntent asks the server to move units.
+type MoveIntent struct {
+	ToLocation Location
+	UnitIDs    []int
+}
+
+// Intent is a change a player asks the server to make to their state.
+// Exactly one of Join, Spawn and Move is set.
+type Intent struct {
+	Username string
+	Join     *JoinIntent
+	Spawn    *SpawnIntent
+	Move     *MoveIntent
+}
+
+// IntentRejection tells a player why the server refused one of their
+// intents.
+type IntentRejection struct {
+	IntentID string
+	Reason   string
+}
+
+// HandleState replaces the player's units with the server's copy of them.
+func (gs *GameState) HandleState(p Player) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== State Update ====")
+	gs.RestorePlayer(p)
+	fmt.Printf("You have %v unit(s).\n", len(p.Units))
+}
+
+// HandleRejection reports an intent the server refused.
+func HandleRejection(rejection IntentRejection) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Intent Rejected ====")
+	fmt.Printf("The server rejected %v: %v\n", rejection.IntentID, rejection.Reason)
+}
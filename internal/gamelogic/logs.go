@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
@@ -14,7 +15,12 @@ const logsFile = "game.log"
 const writeToDiskSleep = 1 * time.Second
 
 func WriteLog(gamelog routing.GameLog) error {
-	log.Printf("received game log...")
+	return WriteLogs([]routing.GameLog{gamelog})
+}
+
+// WriteLogs writes a batch of game logs with a single write to disk.
+func WriteLogs(gamelogs []routing.GameLog) error {
+	log.Printf("received %v game log(s)...", len(gamelogs))
 	time.Sleep(writeToDiskSleep)
 
 	f, err := os.OpenFile(logsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -23,8 +29,11 @@ func WriteLog(gamelog routing.GameLog) error {
 	}
 	defer f.Close()
 
-	str := fmt.Sprintf("%v %v: %v\n", gamelog.CurrentTime.Format(time.RFC3339), gamelog.Username, gamelog.Message)
-	_, err = f.WriteString(str)
+	var str strings.Builder
+	for _, gamelog := range gamelogs {
+		fmt.Fprintf(&str, "%v %v: %v\n", gamelog.CurrentTime.Format(time.RFC3339), gamelog.Username, gamelog.Message)
+	}
+	_, err = f.WriteString(str.String())
 	if err != nil {
 		return fmt.Errorf("could not write to logs file: %v", err)
 	}
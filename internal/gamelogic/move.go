@@ -22,7 +22,7 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 	fmt.Println("==== Move Detected ====")
 	fmt.Printf("%s is moving %v unit(s) to %s\n", move.Player.Username, len(move.Units), move.ToLocation)
 	for _, unit := range move.Units {
-		fmt.Printf("* %v\n", unit.Rank)
+		fmt.Printf("* %v (%v)\n", unit.Rank, unit.Key())
 	}
 
 	if player.Username == move.Player.Username {
@@ -49,44 +49,86 @@ func getOverlappingLocation(p1 Player, p2 Player) Location {
 	return ""
 }
 
-func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
+// CommandMove works out the move described by words without making it.
+func (gs *GameState) CommandMove(words []string) (MoveIntent, error) {
 	if gs.isPaused() {
-		return ArmyMove{}, errors.New("the game is paused, you can not move units")
+		return MoveIntent{}, errors.New("the game is paused, you can not move units")
 	}
 	if len(words) < 3 {
-		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
-	}
-	newLocation := Location(words[1])
-	locations := getAllLocations()
-	if _, ok := locations[newLocation]; !ok {
-		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
+		return MoveIntent{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
 	}
-	unitIDs := []int{}
+	intent := MoveIntent{ToLocation: Location(words[1])}
 	for _, word := range words[2:] {
 		id := word
 		unitID, err := strconv.Atoi(id)
 		if err != nil {
-			return ArmyMove{}, fmt.Errorf("error: %s is not a valid unit ID", id)
+			return MoveIntent{}, fmt.Errorf("error: %s is not a valid unit ID", id)
 		}
-		unitIDs = append(unitIDs, unitID)
+		intent.UnitIDs = append(intent.UnitIDs, unitID)
+	}
+	if _, err := gs.planMove(intent); err != nil {
+		return MoveIntent{}, err
 	}
+	return intent, nil
+}
 
+// planMove works out the move a MoveIntent asks for. Its Player is the
+// player's state once the move is made.
+func (gs *GameState) planMove(intent MoveIntent) (ArmyMove, error) {
+	newLocation := intent.ToLocation
+	rules := gs.rules()
+	if !rules.hasTerritory(newLocation) {
+		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
+	}
+	if len(intent.UnitIDs) == 0 {
+		return ArmyMove{}, errors.New("error: no units to move")
+	}
+
+	player := gs.GetPlayerSnap()
 	newUnits := []Unit{}
-	for _, unitID := range unitIDs {
-		unit, ok := gs.GetUnit(unitID)
+	for _, unitID := range intent.UnitIDs {
+		unit, ok := player.Units[unitID]
 		if !ok {
 			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
 		}
+		unitType, _ := rules.unitType(unit.Rank)
+		if err := rules.Map().checkMove(unit, newLocation, unitType.Movement); err != nil {
+			return ArmyMove{}, err
+		}
 		unit.Location = newLocation
-		gs.UpdateUnit(unit)
+		player.Units[unitID] = unit
 		newUnits = append(newUnits, unit)
 	}
 
-	mv := ArmyMove{
+	return ArmyMove{
 		ToLocation: newLocation,
 		Units:      newUnits,
-		Player:     gs.GetPlayerSnap(),
+		Player:     player,
+	}, nil
+}
+
+// Move makes the move a MoveIntent asks for.
+func (gs *GameState) Move(intent MoveIntent) (ArmyMove, error) {
+	if gs.isPaused() {
+		return ArmyMove{}, errors.New("the game is paused, you can not move units")
 	}
-	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
+	return gs.moveUnchecked(intent)
+}
+
+// moveUnchecked is Move, whether or not the game is paused.
+func (gs *GameState) moveUnchecked(intent MoveIntent) (ArmyMove, error) {
+	mv, err := gs.planMove(intent)
+	if err != nil {
+		return ArmyMove{}, err
+	}
+	gs.ApplyMove(mv)
 	return mv, nil
 }
+
+// ApplyMove moves the units of a move worked out by Move.
+func (gs *GameState) ApplyMove(mv ArmyMove) {
+	for _, unit := range mv.Units {
+		gs.UpdateUnit(unit)
+	}
+	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
+}
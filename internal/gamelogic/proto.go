@@ -0,0 +1,125 @@
+package gamelogic
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/perilpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// Protobuf encodings of the game messages, through the types generated from
+// internal/routing/peril.proto.
+
+// Map entries are sorted, so a message always encodes the same way.
+var marshalOptions = proto.MarshalOptions{Deterministic: true}
+
+func unitToProto(u Unit) *perilpb.Unit {
+	return &perilpb.Unit{
+		Id:       int64(u.ID),
+		Rank:     string(u.Rank),
+		Location: string(u.Location),
+		Owner:    u.Owner,
+	}
+}
+
+func unitFromProto(m *perilpb.Unit) Unit {
+	return Unit{
+		ID:       int(m.GetId()),
+		Rank:     UnitRank(m.GetRank()),
+		Location: Location(m.GetLocation()),
+		Owner:    m.GetOwner(),
+	}
+}
+
+func unitsToProto(units []Unit) []*perilpb.Unit {
+	var out []*perilpb.Unit
+	for _, unit := range units {
+		out = append(out, unitToProto(unit))
+	}
+	return out
+}
+
+func unitsFromProto(units []*perilpb.Unit) []Unit {
+	var out []Unit
+	for _, unit := range units {
+		out = append(out, unitFromProto(unit))
+	}
+	return out
+}
+
+func playerToProto(p Player) *perilpb.Player {
+	m := &perilpb.Player{
+		Username:   p.Username,
+		Units:      map[int64]*perilpb.Unit{},
+		NextUnitId: int64(p.NextUnitID),
+		Funds:      int64(p.Funds),
+	}
+	for id, unit := range p.Units {
+		m.Units[int64(id)] = unitToProto(unit)
+	}
+	return m
+}
+
+func playerFromProto(m *perilpb.Player) Player {
+	p := Player{
+		Username:   m.GetUsername(),
+		Units:      map[int]Unit{},
+		NextUnitID: int(m.GetNextUnitId()),
+		Funds:      int(m.GetFunds()),
+	}
+	for id, unit := range m.GetUnits() {
+		p.Units[int(id)] = unitFromProto(unit)
+	}
+	return p
+}
+
+func (move ArmyMove) MarshalProto() ([]byte, error) {
+	return marshalOptions.Marshal(&perilpb.ArmyMove{
+		Player:     playerToProto(move.Player),
+		Units:      unitsToProto(move.Units),
+		ToLocation: string(move.ToLocation),
+	})
+}
+
+func (move *ArmyMove) UnmarshalProto(b []byte) error {
+	var m perilpb.ArmyMove
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*move = ArmyMove{
+		Player:     playerFromProto(m.GetPlayer()),
+		Units:      unitsFromProto(m.GetUnits()),
+		ToLocation: Location(m.GetToLocation()),
+	}
+	if move.Units == nil {
+		move.Units = []Unit{}
+	}
+	return nil
+}
+
+func (result WarResult) MarshalProto() ([]byte, error) {
+	return marshalOptions.Marshal(&perilpb.WarResult{
+		Attacker:       result.Attacker,
+		Defender:       result.Defender,
+		Winner:         result.Winner,
+		Loser:          result.Loser,
+		Location:       string(result.Location),
+		AttackerLosses: unitsToProto(result.AttackerLosses),
+		DefenderLosses: unitsToProto(result.DefenderLosses),
+	})
+}
+
+func (result *WarResult) UnmarshalProto(b []byte) error {
+	var m perilpb.WarResult
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*result = WarResult{
+		Attacker:       m.GetAttacker(),
+		Defender:       m.GetDefender(),
+		Winner:         m.GetWinner(),
+		Loser:          m.GetLoser(),
+		Location:       Location(m.GetLocation()),
+		AttackerLosses: unitsFromProto(m.GetAttackerLosses()),
+		DefenderLosses: unitsFromProto(m.GetDefenderLosses()),
+	}
+	return nil
+}
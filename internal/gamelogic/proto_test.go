@@ -0,0 +1,110 @@
+package gamelogic
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type protoCase struct {
+	name    string
+	marshal func() ([]byte, error)
+	// unmarshal decodes the protobuf encoding, and gob and json round trip
+	// the value, for comparing with.
+	unmarshal func([]byte) (any, error)
+	gob       func() (any, error)
+	json      func() (any, error)
+}
+
+func newProtoCase[T interface{ MarshalProto() ([]byte, error) }, PT interface {
+	*T
+	UnmarshalProto([]byte) error
+}](name string, value T) protoCase {
+	return protoCase{
+		name:    name,
+		marshal: value.MarshalProto,
+		unmarshal: func(b []byte) (any, error) {
+			var out T
+			err := PT(&out).UnmarshalProto(b)
+			return out, err
+		},
+		gob: func() (any, error) {
+			var buffer bytes.Buffer
+			if err := gob.NewEncoder(&buffer).Encode(value); err != nil {
+				return nil, err
+			}
+			var out T
+			err := gob.NewDecoder(&buffer).Decode(&out)
+			return out, err
+		},
+		json: func() (any, error) {
+			b, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			var out T
+			err = json.Unmarshal(b, &out)
+			return out, err
+		},
+	}
+}
+
+func TestProtoRoundTrips(t *testing.T) {
+	player := Player{
+		Username: "alice",
+		Units: map[int]Unit{
+			1: {ID: 1, Rank: RankInfantry, Location: "europe", Owner: "alice"},
+			3: {ID: 3, Rank: RankArtillery, Location: "asia", Owner: "alice"},
+		},
+		NextUnitID: 4,
+		Funds:      12,
+	}
+	tests := []protoCase{
+		newProtoCase("PlayingState", routing.PlayingState{IsPaused: true}),
+		newProtoCase("GameLog", routing.GameLog{
+			CurrentTime: time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC),
+			Message:     "bob won a war against alice",
+			Username:    "alice",
+		}),
+		newProtoCase("ArmyMove", ArmyMove{
+			Player:     player,
+			Units:      []Unit{player.Units[3]},
+			ToLocation: "asia",
+		}),
+		newProtoCase("WarResult", WarResult{
+			Attacker:       "alice",
+			Defender:       "bob",
+			Winner:         "bob",
+			Loser:          "alice",
+			Location:       "asia",
+			AttackerLosses: []Unit{player.Units[3]},
+			DefenderLosses: []Unit{{ID: 2, Rank: RankCavalry, Location: "asia", Owner: "bob"}},
+		}),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.unmarshal(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for encoding, roundTrip := range map[string]func() (any, error){"gob": tt.gob, "json": tt.json} {
+				want, err := roundTrip()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("round trip = %+v, want %+v as through %v", got, want, encoding)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package gamelogic
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is the rules of a game: the territories and the borders between
+// them, the units players can spawn, and what every player starts with.
+type Scenario struct {
+	Name        string     `json:"name" yaml:"name"`
+	Territories []Location `json:"territories" yaml:"territories"`
+	Borders     []Border   `json:"borders" yaml:"borders"`
+	Units       []UnitType `json:"units" yaml:"units"`
+	Start       Start      `json:"start" yaml:"start"`
+}
+
+// UnitType describes a rank of unit. Power decides wars, Cost is taken from
+// the player's funds when one is spawned, and Movement is how many
+// movement points it can spend in one move.
+type UnitType struct {
+	Rank     UnitRank `json:"rank" yaml:"rank"`
+	Power    int      `json:"power" yaml:"power"`
+	Cost     int      `json:"cost" yaml:"cost"`
+	Movement int      `json:"movement" yaml:"movement"`
+}
+
+// Start is what every player begins the game with.
+type Start struct {
+	Funds int            `json:"funds" yaml:"funds"`
+	Units []StartingUnit `json:"units" yaml:"units"`
+}
+
+type StartingUnit struct {
+	Rank     UnitRank `json:"rank" yaml:"rank"`
+	Location Location `json:"location" yaml:"location"`
+}
+
+//go:embed scenarios/classic.yaml
+var classicScenario []byte
+
+var defaultScenario = sync.OnceValue(func() *Scenario {
+	s, err := ParseScenario(classicScenario, ".yaml")
+	if err != nil {
+		panic(fmt.Sprintf("classic scenario: %v", err))
+	}
+	return s
+})
+
+// DefaultScenario is the classic scenario, which games use unless the
+// server loads another.
+func DefaultScenario() *Scenario {
+	return defaultScenario()
+}
+
+// LoadScenario reads a scenario from a .yaml, .yml or .json file and
+// validates it.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseScenario(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("scenario %v: %w", path, err)
+	}
+	return s, nil
+}
+
+// ParseScenario decodes a scenario in the format of the file extension ext
+// and validates it. Unknown fields are an error, so typos don't silently
+// fall back to defaults.
+func ParseScenario(data []byte, ext string) (*Scenario, error) {
+	s := &Scenario{}
+	switch ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(s); err != nil {
+			return nil, err
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(s); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported scenario format %q, use .yaml, .yml or .json", ext)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate reports everything wrong with the scenario.
+func (s *Scenario) Validate() error {
+	var errs []error
+	if len(s.Territories) == 0 {
+		errs = append(errs, errors.New("no territories"))
+	}
+	territories := map[Location]bool{}
+	for _, loc := range s.Territories {
+		if loc == "" {
+			errs = append(errs, errors.New("territory with no name"))
+		} else if territories[loc] {
+			errs = append(errs, fmt.Errorf("territory %s listed twice", loc))
+		}
+		territories[loc] = true
+	}
+	for _, border := range s.Borders {
+		for _, loc := range []Location{border.From, border.To} {
+			if !territories[loc] {
+				errs = append(errs, fmt.Errorf("border %s-%s: unknown territory %q", border.From, border.To, loc))
+			}
+		}
+		if border.From == border.To {
+			errs = append(errs, fmt.Errorf("border from %s to itself", border.From))
+		}
+	}
+
+	if len(s.Units) == 0 {
+		errs = append(errs, errors.New("no unit types"))
+	}
+	ranks := map[UnitRank]bool{}
+	for _, unit := range s.Units {
+		if unit.Rank == "" {
+			errs = append(errs, errors.New("unit type with no rank"))
+		} else if ranks[unit.Rank] {
+			errs = append(errs, fmt.Errorf("unit type %s listed twice", unit.Rank))
+		}
+		ranks[unit.Rank] = true
+		if unit.Power < 0 || unit.Cost < 0 || unit.Movement < 0 {
+			errs = append(errs, fmt.Errorf("unit type %s: power, cost and movement can't be negative", unit.Rank))
+		}
+		// Units that can move at all should be able to leave anywhere they
+		// can be.
+		if unit.Movement > 0 {
+			for _, loc := range s.stuckIn(unit.Movement) {
+				errs = append(errs, fmt.Errorf("unit type %s: movement %v can't cross any border out of %s", unit.Rank, unit.Movement, loc))
+			}
+		}
+	}
+
+	if s.Start.Funds < 0 {
+		errs = append(errs, errors.New("starting funds can't be negative"))
+	}
+	for _, unit := range s.Start.Units {
+		if !ranks[unit.Rank] {
+			errs = append(errs, fmt.Errorf("starting unit: unknown rank %q", unit.Rank))
+		}
+		if !territories[unit.Location] {
+			errs = append(errs, fmt.Errorf("starting unit: unknown territory %q", unit.Location))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Scenario) hasTerritory(loc Location) bool {
+	for _, territory := range s.Territories {
+		if territory == loc {
+			return true
+		}
+	}
+	return false
+}
+
+// stuckIn lists the territories with borders that a unit with points
+// movement points can't cross any of.
+func (s *Scenario) stuckIn(points int) []Location {
+	m := s.Map()
+	stuck := []Location{}
+	for _, loc := range s.Territories {
+		borders := m.Borders(loc)
+		canLeave := false
+		for _, border := range borders {
+			if border.cost() <= points {
+				canLeave = true
+			}
+		}
+		if len(borders) > 0 && !canLeave {
+			stuck = append(stuck, loc)
+		}
+	}
+	return stuck
+}
+
+func (s *Scenario) unitType(rank UnitRank) (UnitType, bool) {
+	for _, unit := range s.Units {
+		if unit.Rank == rank {
+			return unit, true
+		}
+	}
+	return UnitType{}, false
+}
+
+// Map is the graph of the scenario's territories.
+func (s *Scenario) Map() WorldMap {
+	return NewWorldMap(s.Borders)
+}
+
+func (s *Scenario) powerLevel(units []Unit) int {
+	power := 0
+	for _, unit := range units {
+		unitType, _ := s.unitType(unit.Rank)
+		power += unitType.Power
+	}
+	return power
+}
@@ -5,30 +5,57 @@ import (
 	"fmt"
 )
 
-func (gs *GameState) CommandSpawn(words []string) error {
+// CommandSpawn works out the spawn described by words without making it.
+func (gs *GameState) CommandSpawn(words []string) (SpawnIntent, error) {
 	if len(words) < 3 {
-		return errors.New("usage: spawn <location> <rank>")
+		return SpawnIntent{}, errors.New("usage: spawn <location> <rank>")
 	}
+	intent := SpawnIntent{
+		Location: Location(words[1]),
+		Rank:     UnitRank(words[2]),
+	}
+	unitType, err := gs.validateSpawn(intent)
+	if err != nil {
+		return SpawnIntent{}, err
+	}
+	if funds := gs.GetPlayerSnap().Funds; unitType.Cost > funds {
+		return SpawnIntent{}, fmt.Errorf("error: a(n) %s costs %v and you have %v", intent.Rank, unitType.Cost, funds)
+	}
+	return intent, nil
+}
 
-	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
+func (gs *GameState) validateSpawn(intent SpawnIntent) (UnitType, error) {
+	rules := gs.rules()
+	if !rules.hasTerritory(intent.Location) {
+		return UnitType{}, fmt.Errorf("error: %s is not a valid location", intent.Location)
 	}
 
-	rank := words[2]
-	units := getAllRanks()
-	if _, ok := units[UnitRank(rank)]; !ok {
-		return fmt.Errorf("error: %s is not a valid unit", rank)
+	unitType, ok := rules.unitType(intent.Rank)
+	if !ok {
+		return UnitType{}, fmt.Errorf("error: %s is not a valid unit", intent.Rank)
 	}
+	return unitType, nil
+}
 
-	id := len(gs.getUnitsSnap()) + 1
-	gs.addUnit(Unit{
-		ID:       id,
-		Rank:     UnitRank(rank),
-		Location: Location(locationName),
-	})
+// Spawn spawns the unit a SpawnIntent asks for, paying for it from the
+// player's funds.
+func (gs *GameState) Spawn(intent SpawnIntent) (Unit, error) {
+	unitType, err := gs.validateSpawn(intent)
+	if err != nil {
+		return Unit{}, err
+	}
+	if err := gs.spend(unitType.Cost); err != nil {
+		return Unit{}, err
+	}
+
+	unit := Unit{
+		ID:       gs.allocateUnitID(),
+		Rank:     intent.Rank,
+		Location: intent.Location,
+		Owner:    gs.GetUsername(),
+	}
+	gs.addUnit(unit)
 
-	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
-	return nil
+	fmt.Printf("Spawned a(n) %s in %s with id %v for %s\n", unit.Rank, unit.Location, unit.ID, gs.GetUsername())
+	return unit, nil
 }
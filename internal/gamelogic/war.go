@@ -4,102 +4,89 @@ import (
 	"fmt"
 )
 
-type WarOutcome int
-
-const (
-	WarOutcomeNotInvolved WarOutcome = iota
-	WarOutcomeNoUnits
-	WarOutcomeYouWon
-	WarOutcomeOpponentWon
-	WarOutcomeDraw
-)
-
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
-	defer fmt.Println("------------------------")
-	fmt.Println()
-	fmt.Println("==== War Declared ====")
-	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
-
-	player := gs.GetPlayerSnap()
-
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
-	}
-
-	if player.Username != rw.Attacker.Username {
-		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
-	}
-
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
+// ResolveWar fights the war between the attacker's and the defender's units
+// in the first location they share. The loser's units there are destroyed,
+// or both sides' in a draw. It reports false if they share no location.
+func (s *Scenario) ResolveWar(attacker, defender Player) (WarResult, bool) {
+	overlappingLocation := getOverlappingLocation(attacker, defender)
 	if overlappingLocation == "" {
-		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+		return WarResult{}, false
 	}
 
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
-		}
-	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
-		}
-	}
+	attackerUnits := unitsInLocation(attacker, overlappingLocation)
+	defenderUnits := unitsInLocation(defender, overlappingLocation)
 
-	fmt.Printf("%s's units:\n", rw.Attacker.Username)
+	fmt.Printf("%s's units:\n", attacker.Username)
 	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+		fmt.Printf("  * %v (%v)\n", unit.Rank, unit.Key())
 	}
-	fmt.Printf("%s's units:\n", rw.Defender.Username)
+	fmt.Printf("%s's units:\n", defender.Username)
 	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+		fmt.Printf("  * %v (%v)\n", unit.Rank, unit.Key())
 	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
+	attackerPower := s.powerLevel(attackerUnits)
+	defenderPower := s.powerLevel(defenderUnits)
 	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
 	fmt.Printf("Defender has a power level of %v\n", defenderPower)
+
+	result := WarResult{
+		Attacker: attacker.Username,
+		Defender: defender.Username,
+		Location: overlappingLocation,
+	}
 	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
-		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
+		fmt.Printf("%s has won the war!\n", attacker.Username)
+		result.Winner, result.Loser = attacker.Username, defender.Username
+		result.DefenderLosses = defenderUnits
 	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
-		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
+		fmt.Printf("%s has won the war!\n", defender.Username)
+		result.Winner, result.Loser = defender.Username, attacker.Username
+		result.AttackerLosses = attackerUnits
+	} else {
+		fmt.Println("The war ended in a draw!")
+		result.AttackerLosses = attackerUnits
+		result.DefenderLosses = defenderUnits
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
+	return result, true
 }
 
-func unitsToPowerLevel(units []Unit) int {
-	power := 0
-	for _, unit := range units {
-		if unit.Rank == RankArtillery {
-			power += 10
-		}
-		if unit.Rank == RankCavalry {
-			power += 5
-		}
-		if unit.Rank == RankInfantry {
-			power += 1
+// HandleWarResult removes the player's units destroyed in a war. It reports
+// whether the war concerned the player.
+func (gs *GameState) HandleWarResult(result WarResult) bool {
+	var losses []Unit
+	switch gs.GetUsername() {
+	case result.Attacker:
+		losses = result.AttackerLosses
+	case result.Defender:
+		losses = result.DefenderLosses
+	default:
+		return false
+	}
+
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== War Result ====")
+	switch gs.GetUsername() {
+	case result.Winner:
+		fmt.Printf("You have won the war against %s in %s!\n", result.Loser, result.Location)
+	case result.Loser:
+		fmt.Printf("You have lost the war against %s in %s!\n", result.Winner, result.Location)
+	default:
+		fmt.Printf("The war between %s and %s in %s ended in a draw!\n", result.Attacker, result.Defender, result.Location)
+	}
+	if len(losses) > 0 {
+		gs.removeUnits(losses)
+		fmt.Printf("Your %v unit(s) in %s have been killed.\n", len(losses), result.Location)
+	}
+	return true
+}
+
+func unitsInLocation(p Player, loc Location) []Unit {
+	units := []Unit{}
+	for _, unit := range p.Units {
+		if unit.Location == loc {
+			units = append(units, unit)
 		}
 	}
-	return power
+	return units
 }
@@ -0,0 +1,146 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// World is the server's copy of every player's state. Players change it only
+// through intents, which it checks against their state before applying.
+type World struct {
+	mu       sync.Mutex
+	paused   bool
+	scenario *Scenario
+	players  map[string]*GameState
+}
+
+// NewWorld starts a game of scenario with no players in it yet.
+func NewWorld(scenario *Scenario) *World {
+	return &World{scenario: scenario, players: map[string]*GameState{}}
+}
+
+// player returns username's state, starting it with the scenario's funds
+// and units on first use. Must be called with w.mu held.
+func (w *World) player(username string) *GameState {
+	gs, ok := w.players[username]
+	if !ok {
+		gs = NewGameState(username)
+		gs.Paused = w.paused
+		gs.SetScenario(w.scenario)
+		gs.startGame()
+		w.players[username] = gs
+	}
+	return gs
+}
+
+// IntentResult is what applying an intent changed.
+type IntentResult struct {
+	// Player is the player's state after the intent.
+	Player Player
+	// Move is the move made, if the intent was a move.
+	Move *ArmyMove
+	// Wars are the wars the move started, one for each player whose units
+	// it ran into, already fought.
+	Wars []WarResult
+	// Defenders are the states of the players the wars were fought with,
+	// after their losses.
+	Defenders []Player
+}
+
+// ApplyIntent checks an intent against its player's state and applies it,
+// or returns why it can't be.
+func (w *World) ApplyIntent(intent Intent) (IntentResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.apply(intent, false)
+}
+
+// ReplayIntent applies an intent another server already accepted, to catch
+// up with it. It was checked when the game was last running, so it is applied
+// even while the game is paused.
+func (w *World) ReplayIntent(intent Intent) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.apply(intent, true)
+	return err
+}
+
+// apply applies an intent. Must be called with w.mu held.
+func (w *World) apply(intent Intent, replaying bool) (IntentResult, error) {
+	if intent.Username == "" {
+		return IntentResult{}, errors.New("intent has no username")
+	}
+	gs := w.player(intent.Username)
+
+	result := IntentResult{}
+	switch {
+	case intent.Join != nil:
+
+	case intent.Spawn != nil:
+		if _, err := gs.Spawn(*intent.Spawn); err != nil {
+			return IntentResult{}, err
+		}
+
+	case intent.Move != nil:
+		move := gs.Move
+		if replaying {
+			move = gs.moveUnchecked
+		}
+		mv, err := move(*intent.Move)
+		if err != nil {
+			return IntentResult{}, err
+		}
+		result.Move = &mv
+		w.fightWars(gs, &result)
+
+	default:
+		return IntentResult{}, errors.New("intent asks for nothing")
+	}
+
+	result.Player = gs.GetPlayerSnap()
+	return result, nil
+}
+
+// fightWars fights a war between the player who just moved and each player
+// whose units they ran into, in username order, and applies the losses to
+// both sides. Must be called with w.mu held.
+func (w *World) fightWars(attacker *GameState, result *IntentResult) {
+	usernames := []string{}
+	for username := range w.players {
+		if username != attacker.GetUsername() {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+
+	for _, username := range usernames {
+		defender := w.players[username]
+		war, ok := w.scenario.ResolveWar(attacker.GetPlayerSnap(), defender.GetPlayerSnap())
+		if !ok {
+			continue
+		}
+		fmt.Printf("%s declared war on %s in %s\n", war.Attacker, war.Defender, war.Location)
+		attacker.removeUnits(war.AttackerLosses)
+		defender.removeUnits(war.DefenderLosses)
+		result.Wars = append(result.Wars, war)
+		result.Defenders = append(result.Defenders, defender.GetPlayerSnap())
+	}
+}
+
+// HandlePause pauses or resumes every player.
+func (w *World) HandlePause(ps routing.PlayingState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = ps.IsPaused
+	for _, gs := range w.players {
+		if ps.IsPaused {
+			gs.pauseGame()
+		} else {
+			gs.resumeGame()
+		}
+	}
+}
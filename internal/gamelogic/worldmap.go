@@ -0,0 +1,143 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Crossing a land border costs a unit one movement point, and a sea route
+// two.
+const (
+	landBorderCost = 1
+	seaRouteCost   = 2
+)
+
+// Border connects two territories, both ways. Sea borders are sea routes.
+type Border struct {
+	From Location `json:"from" yaml:"from"`
+	To   Location `json:"to" yaml:"to"`
+	Sea  bool     `json:"sea,omitempty" yaml:"sea,omitempty"`
+}
+
+func (b Border) cost() int {
+	if b.Sea {
+		return seaRouteCost
+	}
+	return landBorderCost
+}
+
+// WorldMap is the graph of territories units move across.
+type WorldMap struct {
+	borders map[Location][]Border
+}
+
+func NewWorldMap(borders []Border) WorldMap {
+	m := WorldMap{borders: map[Location][]Border{}}
+	for _, border := range borders {
+		m.borders[border.From] = append(m.borders[border.From], border)
+		m.borders[border.To] = append(m.borders[border.To], Border{From: border.To, To: border.From, Sea: border.Sea})
+	}
+	return m
+}
+
+// Borders lists the borders leading out of loc.
+func (m WorldMap) Borders(loc Location) []Border {
+	return append([]Border{}, m.borders[loc]...)
+}
+
+// Path finds the cheapest way from one territory to another. It returns the
+// territories along the way, starting with from, and the movement points
+// they take, or false if there is no way there.
+func (m WorldMap) Path(from, to Location) ([]Location, int, bool) {
+	costs := map[Location]int{from: 0}
+	previous := map[Location]Location{}
+	done := map[Location]bool{}
+	for {
+		// The map is small, so the next territory is found by scanning.
+		var current Location
+		found := false
+		for loc, cost := range costs {
+			if !done[loc] && (!found || cost < costs[current] || cost == costs[current] && loc < current) {
+				current, found = loc, true
+			}
+		}
+		if !found {
+			return nil, 0, false
+		}
+		if current == to {
+			break
+		}
+		done[current] = true
+		for _, border := range m.borders[current] {
+			cost := costs[current] + border.cost()
+			if old, ok := costs[border.To]; !ok || cost < old {
+				costs[border.To] = cost
+				previous[border.To] = current
+			}
+		}
+	}
+
+	path := []Location{to}
+	for loc := to; loc != from; {
+		loc = previous[loc]
+		path = append([]Location{loc}, path...)
+	}
+	return path, costs[to], true
+}
+
+// checkMove returns why unit, with points movement points, can't move to
+// loc, if it can't.
+func (m WorldMap) checkMove(unit Unit, loc Location, points int) error {
+	if unit.Location == loc {
+		return fmt.Errorf("error: unit %v is already in %s", unit.ID, loc)
+	}
+	path, cost, ok := m.Path(unit.Location, loc)
+	if !ok {
+		return fmt.Errorf("error: there is no way from %s to %s", unit.Location, loc)
+	}
+	if cost > points {
+		return fmt.Errorf(
+			"error: unit %v can't reach %s from %s: the shortest way (%s) takes %v movement points and %s units have %v",
+			unit.ID, loc, unit.Location, formatPath(path), cost, unit.Rank, points,
+		)
+	}
+	return nil
+}
+
+func formatPath(path []Location) string {
+	names := make([]string, len(path))
+	for i, loc := range path {
+		names[i] = string(loc)
+	}
+	return strings.Join(names, " -> ")
+}
+
+// CommandMap lists every territory with its neighbours, and what each rank
+// of unit costs and can do.
+func (gs *GameState) CommandMap() {
+	rules := gs.rules()
+	m := rules.Map()
+	locations := []string{}
+	for _, loc := range rules.Territories {
+		locations = append(locations, string(loc))
+	}
+	sort.Strings(locations)
+	fmt.Printf("Scenario %s, territories:\n", rules.Name)
+	for _, loc := range locations {
+		neighbours := []string{}
+		for _, border := range m.Borders(Location(loc)) {
+			kind := "land"
+			if border.Sea {
+				kind = "sea"
+			}
+			neighbours = append(neighbours, fmt.Sprintf("%s (%s)", border.To, kind))
+		}
+		sort.Strings(neighbours)
+		fmt.Printf("* %s: %s\n", loc, strings.Join(neighbours, ", "))
+	}
+	fmt.Println("Units:")
+	for _, unit := range rules.Units {
+		fmt.Printf("* %s: power %v, cost %v, movement %v\n", unit.Rank, unit.Power, unit.Cost, unit.Movement)
+	}
+}
@@ -0,0 +1,107 @@
+package gamelogic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWorldMapPath(t *testing.T) {
+	m := DefaultScenario().Map()
+	tests := []struct {
+		from, to Location
+		path     []Location
+		cost     int
+		ok       bool
+	}{
+		{"europe", "europe", []Location{"europe"}, 0, true},
+		{"europe", "asia", []Location{"europe", "asia"}, 1, true},
+		{"asia", "europe", []Location{"asia", "europe"}, 1, true},
+		{"americas", "europe", []Location{"americas", "europe"}, 2, true},
+		{"europe", "australia", []Location{"europe", "asia", "australia"}, 3, true},
+		// Ties go to the territory first in alphabetical order, so the
+		// same path is always picked.
+		{"americas", "australia", []Location{"americas", "antarctica", "australia"}, 4, true},
+		{"europe", "atlantis", nil, 0, false},
+	}
+	for _, tt := range tests {
+		path, cost, ok := m.Path(tt.from, tt.to)
+		if ok != tt.ok || cost != tt.cost || !reflect.DeepEqual(path, tt.path) {
+			t.Errorf("Path(%s, %s) = %v, %v, %v, want %v, %v, %v", tt.from, tt.to, path, cost, ok, tt.path, tt.cost, tt.ok)
+		}
+	}
+}
+
+func TestWorldMapPathIsolated(t *testing.T) {
+	m := NewWorldMap([]Border{{From: "europe", To: "asia"}, {From: "americas", To: "antarctica", Sea: true}})
+	if path, _, ok := m.Path("europe", "americas"); ok {
+		t.Errorf("Path between unconnected territories = %v, want none", path)
+	}
+}
+
+func TestCheckMove(t *testing.T) {
+	m := DefaultScenario().Map()
+	tests := []struct {
+		name   string
+		from   Location
+		to     Location
+		points int
+		err    string
+	}{
+		{"land border", "europe", "asia", 1, ""},
+		{"sea route", "americas", "europe", 2, ""},
+		{"sea route too far", "americas", "europe", 1, "takes 2 movement points and infantry units have 1"},
+		{"several borders", "europe", "australia", 3, ""},
+		{"several borders too far", "europe", "australia", 2, "europe -> asia -> australia"},
+		{"same territory", "europe", "europe", 4, "already in europe"},
+		{"no way there", "europe", "atlantis", 4, "no way from europe to atlantis"},
+	}
+	for _, tt := range tests {
+		unit := Unit{ID: 1, Rank: RankInfantry, Location: tt.from}
+		err := m.checkMove(unit, tt.to, tt.points)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: checkMove() = %v, want nil", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: checkMove() = %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestClassicUnitsCanLeaveEveryTerritory(t *testing.T) {
+	s := DefaultScenario()
+	m := s.Map()
+	for _, unitType := range s.Units {
+		for _, from := range s.Territories {
+			canLeave := false
+			for _, to := range s.Territories {
+				unit := Unit{ID: 1, Rank: unitType.Rank, Location: from}
+				if to != from && m.checkMove(unit, to, unitType.Movement) == nil {
+					canLeave = true
+				}
+			}
+			if !canLeave {
+				t.Errorf("%s units can't leave %s", unitType.Rank, from)
+			}
+		}
+	}
+}
+
+func TestValidateStuckUnits(t *testing.T) {
+	s := *DefaultScenario()
+	s.Units = append([]UnitType{}, s.Units...)
+	for i := range s.Units {
+		if s.Units[i].Rank == RankArtillery {
+			s.Units[i].Movement = 1
+		}
+	}
+	err := s.Validate()
+	if err == nil {
+		t.Fatal("Validate() = nil, want an error for artillery stuck in americas")
+	}
+	for _, loc := range []string{"americas", "australia", "antarctica"} {
+		if !strings.Contains(err.Error(), "movement 1 can't cross any border out of "+loc) {
+			t.Errorf("Validate() = %v, want it to mention %s", err, loc)
+		}
+	}
+}
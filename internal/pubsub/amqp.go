@@ -0,0 +1,528 @@
+package pubsub
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+    reconnectMinDelay = 500 * time.Millisecond
+    reconnectMaxDelay = 30 * time.Second
+)
+
+var ErrNotConnected = errors.New("not connected to rabbitmq server")
+
+type exchangeDeclaration struct {
+    name string
+    kind string
+    durable bool
+}
+
+type queueDeclaration struct {
+    name string
+    durable bool
+    autoDelete bool
+    exclusive bool
+    args amqp.Table
+}
+
+type queueBinding struct {
+    queueName string
+    key string
+    exchange string
+}
+
+// AMQPBroker is a Broker backed by a RabbitMQ connection. When the connection
+// drops it redials with exponential backoff, re-declares every exchange, queue
+// and binding declared through it and not deleted since, and restarts its
+// consumers. Delivery channels returned by Consume stay open across
+// reconnects.
+type AMQPBroker struct {
+    url string
+
+    mu sync.Mutex
+    connection *amqp.Connection
+    publishChannel *amqp.Channel
+    connectionClosed chan *amqp.Error
+    channelClosed chan *amqp.Error
+    ready chan struct{}
+    closed bool
+    done chan struct{}
+    consumerSeq int
+
+    exchanges []exchangeDeclaration
+    queues []queueDeclaration
+    bindings []queueBinding
+
+    // Confirmed publishes are serialized so a basic.return can be matched to
+    // the publish it belongs to.
+    confirmMu sync.Mutex
+    confirmChannel *amqp.Channel
+    returns chan amqp.Return
+}
+
+func DialAMQP(url string) (*AMQPBroker, error) {
+    b := &AMQPBroker {
+        url: url,
+        ready: make(chan struct{}),
+        done: make(chan struct{}),
+    }
+    if err := b.connect(); err != nil { return nil, err }
+    go b.watch()
+    return b, nil
+}
+
+func (b *AMQPBroker) connect() error {
+    connection, err := amqp.Dial(b.url)
+    if err != nil { return err }
+    publishChannel, err := connection.Channel()
+    if err != nil {
+        connection.Close()
+        return err
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.closed {
+        connection.Close()
+        return amqp.ErrClosed
+    }
+    if err := b.declareTopology(connection); err != nil {
+        connection.Close()
+        return err
+    }
+    b.connection = connection
+    b.publishChannel = publishChannel
+    b.connectionClosed = connection.NotifyClose(make(chan *amqp.Error, 1))
+    b.channelClosed = publishChannel.NotifyClose(make(chan *amqp.Error, 1))
+    close(b.ready)
+    return nil
+}
+
+// declareTopology replays the recorded declarations on a fresh connection.
+// Must be called with b.mu held.
+func (b *AMQPBroker) declareTopology(connection *amqp.Connection) error {
+    channel, err := connection.Channel()
+    if err != nil { return err }
+    defer channel.Close()
+
+    for _, ex := range b.exchanges {
+        if err := channel.ExchangeDeclare(ex.name, ex.kind, ex.durable, false, false, false, nil); err != nil {
+            return err
+        }
+    }
+    for _, q := range b.queues {
+        if _, err := channel.QueueDeclare(q.name, q.durable, q.autoDelete, q.exclusive, false, q.args); err != nil {
+            return err
+        }
+    }
+    for _, binding := range b.bindings {
+        if err := channel.QueueBind(binding.queueName, binding.key, binding.exchange, false, nil); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (b *AMQPBroker) watch() {
+    for {
+        b.mu.Lock()
+        connection := b.connection
+        connectionClosed := b.connectionClosed
+        channelClosed := b.channelClosed
+        b.mu.Unlock()
+
+        select {
+        case <-b.done:
+            return
+        case reason := <-channelClosed:
+            if !connection.IsClosed() {
+                if err := b.reopenPublishChannel(connection); err == nil { continue }
+            }
+            b.reconnect(reason)
+        case reason := <-connectionClosed:
+            b.reconnect(reason)
+        }
+    }
+}
+
+func (b *AMQPBroker) reopenPublishChannel(connection *amqp.Connection) error {
+    publishChannel, err := connection.Channel()
+    if err != nil { return err }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.publishChannel = publishChannel
+    b.channelClosed = publishChannel.NotifyClose(make(chan *amqp.Error, 1))
+    return nil
+}
+
+func (b *AMQPBroker) reconnect(reason *amqp.Error) {
+    b.mu.Lock()
+    if b.closed {
+        b.mu.Unlock()
+        return
+    }
+    b.ready = make(chan struct{})
+    b.connection.Close()
+    b.mu.Unlock()
+
+    fmt.Printf("Lost connection to rabbitmq server: %v\n", reason)
+    delay := reconnectMinDelay
+    for {
+        if !b.sleep(delay) { return }
+        err := b.connect()
+        if err == nil {
+            fmt.Println("Reconnected to rabbitmq server")
+            return
+        }
+        fmt.Printf("Failed to reconnect to rabbitmq server: %v\n", err)
+        delay = min(delay * 2, reconnectMaxDelay)
+    }
+}
+
+// sleep waits for delay and reports false if the broker was closed meanwhile.
+func (b *AMQPBroker) sleep(delay time.Duration) bool {
+    select {
+    case <-b.done: return false
+    case <-time.After(delay): return true
+    }
+}
+
+// waitReady blocks until the broker is connected and reports false if it was
+// closed instead.
+func (b *AMQPBroker) waitReady() bool {
+    b.mu.Lock()
+    ready := b.ready
+    b.mu.Unlock()
+    select {
+    case <-b.done: return false
+    case <-ready: return true
+    }
+}
+
+func (b *AMQPBroker) isReady() bool {
+    select {
+    case <-b.ready: return !b.closed
+    default: return false
+    }
+}
+
+// withChannel runs fn on a short lived channel. A failed declaration closes
+// the channel it was made on, so declarations never share one. Must be called
+// with b.mu held.
+func (b *AMQPBroker) withChannel(fn func(*amqp.Channel) error) error {
+    if !b.isReady() { return ErrNotConnected }
+    channel, err := b.connection.Channel()
+    if err != nil { return err }
+    defer channel.Close()
+    return fn(channel)
+}
+
+func (b *AMQPBroker) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+    b.mu.Lock()
+    if !b.isReady() {
+        b.mu.Unlock()
+        return ErrNotConnected
+    }
+    publishChannel := b.publishChannel
+    b.mu.Unlock()
+    return publishChannel.PublishWithContext(ctx, exchange, key, false, false, msg)
+}
+
+// openConfirmChannel returns the channel used for confirmed publishes,
+// opening a new one if there is none or the last one was closed. Must be
+// called with b.confirmMu held.
+func (b *AMQPBroker) openConfirmChannel() (*amqp.Channel, error) {
+    if b.confirmChannel != nil && !b.confirmChannel.IsClosed() {
+        return b.confirmChannel, nil
+    }
+
+    b.mu.Lock()
+    if !b.isReady() {
+        b.mu.Unlock()
+        return nil, ErrNotConnected
+    }
+    connection := b.connection
+    b.mu.Unlock()
+
+    channel, err := connection.Channel()
+    if err != nil { return nil, err }
+    if err := channel.Confirm(false); err != nil {
+        channel.Close()
+        return nil, err
+    }
+    b.confirmChannel = channel
+    b.returns = channel.NotifyReturn(make(chan amqp.Return, 1))
+    return channel, nil
+}
+
+func (b *AMQPBroker) PublishConfirmed(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+    b.confirmMu.Lock()
+    defer b.confirmMu.Unlock()
+
+    channel, err := b.openConfirmChannel()
+    if err != nil { return err }
+    confirmation, err := channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+    if err != nil { return err }
+
+    acked, err := confirmation.WaitContext(ctx)
+    if err != nil {
+        // A late return or ack would be mistaken for the next publish's.
+        channel.Close()
+        return &PublishError { Exchange: exchange, Key: key, Err: err }
+    }
+
+    // RabbitMQ sends basic.return before the ack of the same message. A
+    // closed channel closes the returns too, and settles the confirmation as
+    // a nack.
+    select {
+    case returned, ok := <-b.returns:
+        if !ok { return &PublishError { Exchange: exchange, Key: key, Err: ErrChannelClosed } }
+        return &PublishError {
+            Exchange: exchange,
+            Key: key,
+            ReplyCode: returned.ReplyCode,
+            ReplyText: returned.ReplyText,
+            Err: ErrUnroutable,
+        }
+    default:
+    }
+    if !acked && channel.IsClosed() {
+        return &PublishError { Exchange: exchange, Key: key, Err: ErrChannelClosed }
+    }
+    if !acked {
+        return &PublishError { Exchange: exchange, Key: key, Err: ErrNacked }
+    }
+    return nil
+}
+
+func (b *AMQPBroker) ExchangeDeclare(name, kind string, durable bool) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    err := b.withChannel(func(channel *amqp.Channel) error {
+        return channel.ExchangeDeclare(name, kind, durable, false, false, false, nil)
+    })
+    if err != nil { return err }
+
+    for _, ex := range b.exchanges {
+        if ex.name == name { return nil }
+    }
+    b.exchanges = append(b.exchanges, exchangeDeclaration { name: name, kind: kind, durable: durable })
+    return nil
+}
+
+func (b *AMQPBroker) QueueDeclare(
+    name string,
+    durable,
+    autoDelete,
+    exclusive bool,
+    args amqp.Table,
+) (amqp.Queue, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    var queue amqp.Queue
+    err := b.withChannel(func(channel *amqp.Channel) error {
+        var err error
+        queue, err = channel.QueueDeclare(name, durable, autoDelete, exclusive, false, args)
+        return err
+    })
+    if err != nil { return queue, err }
+
+    // A server-named queue gets a new name when declared again, so it can't
+    // be replayed. Name queues that must survive a reconnect.
+    if name == "" { return queue, nil }
+    for _, q := range b.queues {
+        if q.name == name { return queue, nil }
+    }
+    b.queues = append(b.queues, queueDeclaration {
+        name: name,
+        durable: durable,
+        autoDelete: autoDelete,
+        exclusive: exclusive,
+        args: args,
+    })
+    return queue, nil
+}
+
+func (b *AMQPBroker) QueueBind(queueName, key, exchange string) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    err := b.withChannel(func(channel *amqp.Channel) error {
+        return channel.QueueBind(queueName, key, exchange, false, nil)
+    })
+    if err != nil { return err }
+
+    binding := queueBinding { queueName: queueName, key: key, exchange: exchange }
+    for _, existing := range b.bindings {
+        if existing == binding { return nil }
+    }
+    b.bindings = append(b.bindings, binding)
+    return nil
+}
+
+// QueueDelete deletes a queue, and stops declaring it and its bindings again
+// after a reconnect. Queues that go away by themselves, like a closed RPC
+// client's, are declared again unless deleted.
+func (b *AMQPBroker) QueueDelete(name string) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    queues := b.queues[:0]
+    for _, q := range b.queues {
+        if q.name != name { queues = append(queues, q) }
+    }
+    b.queues = queues
+    bindings := b.bindings[:0]
+    for _, binding := range b.bindings {
+        if binding.queueName != name { bindings = append(bindings, binding) }
+    }
+    b.bindings = bindings
+
+    return b.withChannel(func(channel *amqp.Channel) error {
+        _, err := channel.QueueDelete(name, false, false, false)
+        return err
+    })
+}
+
+// amqpConsumer keeps consuming from a queue across reconnects, opening a new
+// channel whenever the one underneath is closed.
+type amqpConsumer struct {
+    broker *AMQPBroker
+    queueName string
+    prefetch int
+    deliveries chan amqp.Delivery
+
+    mu sync.Mutex
+    channel *amqp.Channel
+    tag string
+    cancelled chan struct{}
+    closed bool
+}
+
+func (b *AMQPBroker) Consume(queueName string, prefetch int) (Consumer, error) {
+    consumer := &amqpConsumer {
+        broker: b,
+        queueName: queueName,
+        prefetch: prefetch,
+        deliveries: make(chan amqp.Delivery),
+        cancelled: make(chan struct{}),
+    }
+    deliveryChannel, err := consumer.consume()
+    if err != nil { return nil, err }
+    go consumer.forward(deliveryChannel)
+    return consumer, nil
+}
+
+func (c *amqpConsumer) consume() (<-chan amqp.Delivery, error) {
+    b := c.broker
+    b.mu.Lock()
+    if !b.isReady() {
+        b.mu.Unlock()
+        return nil, ErrNotConnected
+    }
+    connection := b.connection
+    b.consumerSeq++
+    tag := fmt.Sprintf("%v-%v", c.queueName, b.consumerSeq)
+    b.mu.Unlock()
+
+    channel, err := connection.Channel()
+    if err != nil { return nil, err }
+    if err := channel.Qos(c.prefetch, 0, false); err != nil {
+        channel.Close()
+        return nil, err
+    }
+    deliveryChannel, err := channel.Consume(c.queueName, tag, false, false, false, false, nil)
+    if err != nil {
+        channel.Close()
+        return nil, err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.channel = channel
+    c.tag = tag
+    return deliveryChannel, nil
+}
+
+// forward copies deliveries to the consumer's delivery channel, restarting
+// the consumer whenever its channel is closed, until it is cancelled or the
+// broker itself is closed.
+func (c *amqpConsumer) forward(deliveryChannel <-chan amqp.Delivery) {
+    defer close(c.deliveries)
+    for {
+        for delivery := range deliveryChannel {
+            select {
+            case c.deliveries <- delivery:
+            case <-c.cancelled:
+                // Left unacked, it is requeued when the channel closes.
+                return
+            }
+        }
+
+        delay := reconnectMinDelay
+        for {
+            select {
+            case <-c.cancelled: return
+            default:
+            }
+            if !c.broker.waitReady() { return }
+            var err error
+            deliveryChannel, err = c.consume()
+            if err == nil { break }
+            var amqpErr *amqp.Error
+            if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+                // A server-named queue is gone for good after a reconnect.
+                fmt.Printf("Stopped consumer on %v: %v\n", c.queueName, err)
+                return
+            }
+            fmt.Printf("Failed to restart consumer on %v: %v\n", c.queueName, err)
+            if !c.broker.sleep(delay) { return }
+            delay = min(delay * 2, reconnectMaxDelay)
+        }
+    }
+}
+
+func (c *amqpConsumer) Deliveries() <-chan amqp.Delivery {
+    return c.deliveries
+}
+
+func (c *amqpConsumer) Cancel() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    select {
+    case <-c.cancelled: return nil
+    default:
+    }
+    close(c.cancelled)
+    if err := c.channel.Cancel(c.tag, false); err != nil && !errors.Is(err, amqp.ErrClosed) {
+        return err
+    }
+    return nil
+}
+
+func (c *amqpConsumer) Close() error {
+    if err := c.Cancel(); err != nil { return err }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.closed { return nil }
+    c.closed = true
+    if err := c.channel.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+        return err
+    }
+    return nil
+}
+
+func (b *AMQPBroker) Close() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.closed { return nil }
+    b.closed = true
+    close(b.done)
+    if err := b.connection.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+        return err
+    }
+    return nil
+}
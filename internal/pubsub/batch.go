@@ -0,0 +1,256 @@
+package pubsub
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// A batch carries this header with the number of messages in it. Its body
+// is the messages encoded as one slice.
+const HeaderBatchSize = "x-batch-size"
+
+var ErrBatcherClosed = errors.New("batcher is closed")
+
+type batchOptions struct {
+    maxMessages int
+    maxBytes int
+    window time.Duration
+    compression string
+}
+
+type BatchOption func(*batchOptions)
+
+// BatchSize publishes a key's batch once it holds n messages.
+func BatchSize(n int) BatchOption {
+    return func(options *batchOptions) {
+        if n > 0 { options.maxMessages = n }
+    }
+}
+
+// BatchBytes publishes a key's batch once its messages add up to n bytes
+// before compression.
+func BatchBytes(n int) BatchOption {
+    return func(options *batchOptions) {
+        if n > 0 { options.maxBytes = n }
+    }
+}
+
+// BatchWindow publishes a key's batch at most window after its first
+// message was added.
+func BatchWindow(window time.Duration) BatchOption {
+    return func(options *batchOptions) {
+        if window > 0 { options.window = window }
+    }
+}
+
+// BatchCompression compresses batches with CompressionGzip or
+// CompressionZstd.
+func BatchCompression(compression string) BatchOption {
+    return func(options *batchOptions) {
+        options.compression = compression
+    }
+}
+
+type batch[T any] struct {
+    messages []T
+    bytes int
+    timer *time.Timer
+}
+
+// Batcher coalesces messages published to the same routing key into a
+// single message. Receive them with SubscribeBatch. A batch that fails to
+// publish is kept, ahead of any messages added since, and tried again when
+// the key's batch is next published.
+type Batcher[T any] struct {
+    publisher Publisher
+    codec Codec
+    exchange string
+    options batchOptions
+
+    mu sync.Mutex
+    batches map[string]*batch[T]
+    closed bool
+    // Batches are published one at a time so a key's batches stay in order.
+    publishMu sync.Mutex
+}
+
+// NewBatcher batches messages for exchange, encoding them with the codec
+// registered under codecName. By default a batch is published once it
+// holds 100 messages or 64KiB, or after a second.
+func NewBatcher[T any](publisher Publisher, codecName, exchange string, opts ...BatchOption) (*Batcher[T], error) {
+    codec, err := codecByName(codecName)
+    if err != nil { return nil, err }
+    options := batchOptions {
+        maxMessages: 100,
+        maxBytes: 64 * 1024,
+        window: time.Second,
+    }
+    for _, opt := range opts {
+        opt(&options)
+    }
+    if _, err := compress(nil, options.compression); err != nil { return nil, err }
+    return &Batcher[T] {
+        publisher: publisher,
+        codec: codec,
+        exchange: exchange,
+        options: options,
+        batches: map[string]*batch[T] {},
+    }, nil
+}
+
+// Add adds val to key's batch, publishing the batch if it is full.
+func (b *Batcher[T]) Add(key string, val T) error {
+    encoded, err := b.codec.Encode(val)
+    if err != nil { return err }
+
+    b.mu.Lock()
+    if b.closed {
+        b.mu.Unlock()
+        return ErrBatcherClosed
+    }
+    current := b.batchFor(key)
+    current.messages = append(current.messages, val)
+    current.bytes += len(encoded)
+    full := len(current.messages) >= b.options.maxMessages || current.bytes >= b.options.maxBytes
+    b.mu.Unlock()
+
+    if full { return b.flush(key, current) }
+    return nil
+}
+
+// batchFor returns key's batch, starting it if there is none, along with the
+// timer publishing it at the end of the window unless the Batcher is closed.
+// Must be called with b.mu held.
+func (b *Batcher[T]) batchFor(key string) *batch[T] {
+    current, ok := b.batches[key]
+    if ok { return current }
+    current = &batch[T] {}
+    if !b.closed {
+        current.timer = time.AfterFunc(b.options.window, func() {
+            if err := b.flush(key, current); err != nil {
+                fmt.Printf("Failed to publish batch to %v, retrying in %v: %v\n", key, b.options.window, err)
+            }
+        })
+    }
+    b.batches[key] = current
+    return current
+}
+
+// flush publishes key's batch, unless it has been published already. If
+// publishing fails, the messages go back in the key's batch.
+func (b *Batcher[T]) flush(key string, expected *batch[T]) error {
+    b.publishMu.Lock()
+    defer b.publishMu.Unlock()
+    b.mu.Lock()
+    current, ok := b.batches[key]
+    if !ok || current != expected {
+        b.mu.Unlock()
+        return nil
+    }
+    delete(b.batches, key)
+    if current.timer != nil { current.timer.Stop() }
+    b.mu.Unlock()
+
+    err := b.publish(key, current.messages)
+    if err == nil { return nil }
+    b.mu.Lock()
+    next := b.batchFor(key)
+    next.messages = append(current.messages, next.messages...)
+    next.bytes += current.bytes
+    b.mu.Unlock()
+    return err
+}
+
+func (b *Batcher[T]) publish(key string, messages []T) error {
+    body, err := b.codec.Encode(messages)
+    if err != nil { return err }
+    body, err = compress(body, b.options.compression)
+    if err != nil { return err }
+
+    publishing := amqp.Publishing {
+        ContentType: b.codec.ContentType(),
+        ContentEncoding: b.options.compression,
+        MessageId: NewMessageID(),
+        Headers: amqp.Table { HeaderBatchSize: int64(len(messages)) },
+        Body: body,
+    }
+    ctx, span := startPublishSpan(context.Background(), b.exchange, key, &publishing)
+    err = b.publisher.Publish(ctx, b.exchange, key, publishing)
+    endSpan(span, err)
+    if err != nil { return err }
+    messagesPublished.WithLabelValues(b.exchange, keyPrefix(key)).Add(float64(len(messages)))
+    return nil
+}
+
+// Flush publishes every batch now.
+func (b *Batcher[T]) Flush() error {
+    b.mu.Lock()
+    pending := map[string]*batch[T] {}
+    for key, current := range b.batches {
+        pending[key] = current
+    }
+    b.mu.Unlock()
+
+    var firstErr error
+    for key, current := range pending {
+        if err := b.flush(key, current); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    return firstErr
+}
+
+// Close stops the window timers and publishes every batch. Add fails once the
+// Batcher is closed. Batches that fail to publish are kept for Flush to try
+// again.
+func (b *Batcher[T]) Close() error {
+    b.mu.Lock()
+    b.closed = true
+    for _, current := range b.batches {
+        if current.timer != nil { current.timer.Stop() }
+    }
+    b.mu.Unlock()
+    return b.Flush()
+}
+
+// decodeBatch decodes a batch, or a single message published without a
+// Batcher as a batch of one.
+func decodeBatch[T any](message amqp.Delivery, out *[]T) error {
+    if _, ok := message.Headers[HeaderBatchSize]; ok {
+        return Decode(message, out)
+    }
+    var one T
+    if err := Decode(message, &one); err != nil { return err }
+    *out = []T { one }
+    return nil
+}
+
+// SubscribeBatch is like Subscribe for messages published with a Batcher.
+// The handler gets a whole batch, which is acked, requeued or discarded as
+// one message.
+func SubscribeBatch[T any](
+    ctx context.Context,
+    subscriber Subscriber,
+    exchange,
+    queueName,
+    key string,
+    queueType QueueType,
+    handler func([]T) AckType,
+    opts ...SubscribeOption,
+) (*Subscription, error) {
+    return subscribe(
+        ctx,
+        subscriber,
+        exchange,
+        queueName,
+        key,
+        queueType,
+        bodyOnly(handler),
+        decodeBatch[T],
+        opts,
+    )
+}
@@ -0,0 +1,91 @@
+package pubsub
+
+import (
+    "context"
+    "errors"
+    "reflect"
+    "sync"
+    "testing"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// flakyPublisher fails as many publishes as its failures, then records them.
+type flakyPublisher struct {
+    mu sync.Mutex
+    failures int
+    published []amqp.Publishing
+}
+
+func (p *flakyPublisher) Publish(_ context.Context, exchange, key string, msg amqp.Publishing) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.failures > 0 {
+        p.failures--
+        return errors.New("connection lost")
+    }
+    p.published = append(p.published, msg)
+    return nil
+}
+
+// batches decodes the batches published so far.
+func (p *flakyPublisher) batches(t *testing.T) [][]string {
+    t.Helper()
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    batches := [][]string {}
+    for _, publishing := range p.published {
+        var batch []string
+        must(t, Decode(amqp.Delivery { ContentType: publishing.ContentType, Headers: publishing.Headers, Body: publishing.Body }, &batch))
+        batches = append(batches, batch)
+    }
+    return batches
+}
+
+func TestBatcherRetriesBatchAfterWindowFlushFails(t *testing.T) {
+    publisher := &flakyPublisher { failures: 1 }
+    batcher, err := NewBatcher[string](publisher, CodecJSON, "logs", BatchWindow(20 * time.Millisecond))
+    must(t, err)
+    defer batcher.Close()
+    must(t, batcher.Add("logs.bob", "a"))
+    must(t, batcher.Add("logs.bob", "b"))
+
+    deadline := time.Now().Add(testTimeout)
+    for len(publisher.batches(t)) == 0 {
+        if time.Now().After(deadline) { t.Fatal("timed out waiting for the batch to be retried") }
+        time.Sleep(5 * time.Millisecond)
+    }
+    if got, want := publisher.batches(t), [][]string { { "a", "b" } }; !reflect.DeepEqual(got, want) {
+        t.Errorf("published %v, want %v", got, want)
+    }
+}
+
+func TestBatcherKeepsFullBatchThatFailsToPublish(t *testing.T) {
+    publisher := &flakyPublisher { failures: 1 }
+    batcher, err := NewBatcher[string](publisher, CodecJSON, "logs", BatchSize(2), BatchWindow(time.Hour))
+    must(t, err)
+    defer batcher.Close()
+    must(t, batcher.Add("logs.bob", "a"))
+    if err := batcher.Add("logs.bob", "b"); err == nil { t.Fatal("Add of a full batch succeeded, want the publish error") }
+    must(t, batcher.Add("logs.bob", "c"))
+
+    if got, want := publisher.batches(t), [][]string { { "a", "b", "c" } }; !reflect.DeepEqual(got, want) {
+        t.Errorf("published %v, want %v", got, want)
+    }
+}
+
+func TestBatcherCloseFlushesPartialBatches(t *testing.T) {
+    publisher := &flakyPublisher {}
+    batcher, err := NewBatcher[string](publisher, CodecJSON, "logs", BatchWindow(20 * time.Millisecond))
+    must(t, err)
+    must(t, batcher.Add("logs.bob", "a"))
+    must(t, batcher.Close())
+    if err := batcher.Add("logs.bob", "b"); !errors.Is(err, ErrBatcherClosed) {
+        t.Errorf("Add after Close error = %v, want %v", err, ErrBatcherClosed)
+    }
+
+    time.Sleep(50 * time.Millisecond)
+    if got, want := publisher.batches(t), [][]string { { "a" } }; !reflect.DeepEqual(got, want) {
+        t.Errorf("published %v, want %v", got, want)
+    }
+}
@@ -0,0 +1,41 @@
+package pubsub
+
+import (
+    "context"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publisher sends messages to an exchange.
+type Publisher interface {
+    Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+}
+
+// Subscriber declares exchanges, queues and bindings, and consumes deliveries
+// from queues. Deliveries must be acked or nacked through their Acknowledger.
+type Subscriber interface {
+    ExchangeDeclare(name, kind string, durable bool) error
+    QueueDeclare(name string, durable, autoDelete, exclusive bool, args amqp.Table) (amqp.Queue, error)
+    QueueBind(queueName, key, exchange string) error
+    // QueueDelete deletes a queue along with its bindings.
+    QueueDelete(name string) error
+    Consume(queueName string, prefetch int) (Consumer, error)
+}
+
+// Consumer is a running consumer on a queue. Cancel stops new deliveries and
+// closes the delivery channel, while deliveries already received can still be
+// acked. Close also closes the channel underneath, requeueing anything left
+// unacked.
+type Consumer interface {
+    Deliveries() <-chan amqp.Delivery
+    Cancel() error
+    Close() error
+}
+
+// Broker is a connection to a message broker. AMQPBroker talks to RabbitMQ,
+// MemoryBroker connections stay in process.
+type Broker interface {
+    Publisher
+    ConfirmPublisher
+    Subscriber
+    Close() error
+}
@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+    "bytes"
+    "encoding/gob"
+    "encoding/json"
+    "fmt"
+    "sync"
+)
+
+// Codec turns message values into bodies of a single content type and back.
+type Codec interface {
+    ContentType() string
+    Encode(val any) ([]byte, error)
+    Decode(data []byte, out any) error
+}
+
+const (
+    CodecJSON = "json"
+    CodecGob = "gob"
+)
+
+var (
+    codecsMu sync.RWMutex
+    codecsByName = map[string]Codec {}
+    codecsByContentType = map[string]Codec {}
+)
+
+func init() {
+    RegisterCodec(CodecJSON, jsonCodec{})
+    RegisterCodec(CodecGob, gobCodec{})
+}
+
+// CodecFor names a registered codec that can encode and decode T. Codecs
+// that only some types support, like Protobuf, have constructors that
+// only accept those types, so picking one T can't be encoded with fails
+// to compile.
+type CodecFor[T any] struct {
+    name string
+}
+
+// Name is the name the codec is registered under.
+func (c CodecFor[T]) Name() string { return c.name }
+
+func JSON[T any]() CodecFor[T] { return CodecFor[T] { name: CodecJSON } }
+
+func Gob[T any]() CodecFor[T] { return CodecFor[T] { name: CodecGob } }
+
+// RegisterCodec makes codec available to Publish under name, and to
+// Subscribe for deliveries with the codec's content type.
+func RegisterCodec(name string, codec Codec) {
+    codecsMu.Lock()
+    defer codecsMu.Unlock()
+    codecsByName[name] = codec
+    codecsByContentType[codec.ContentType()] = codec
+}
+
+func codecByName(name string) (Codec, error) {
+    codecsMu.RLock()
+    defer codecsMu.RUnlock()
+    codec, ok := codecsByName[name]
+    if !ok { return nil, fmt.Errorf("no codec named %q", name) }
+    return codec, nil
+}
+
+func codecForContentType(contentType string) (Codec, error) {
+    codecsMu.RLock()
+    defer codecsMu.RUnlock()
+    codec, ok := codecsByContentType[contentType]
+    if !ok { return nil, fmt.Errorf("no codec for content type %q", contentType) }
+    return codec, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Encode(val any) ([]byte, error) {
+    return json.Marshal(val)
+}
+
+func (jsonCodec) Decode(data []byte, out any) error {
+    return json.Unmarshal(data, out)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string { return "application/gob" }
+
+func (gobCodec) Encode(val any) ([]byte, error) {
+    var buffer bytes.Buffer
+    encoder := gob.NewEncoder(&buffer)
+    if err := encoder.Encode(val); err != nil { return nil, err }
+    return buffer.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, out any) error {
+    buffer := bytes.NewBuffer(data)
+    decoder := gob.NewDecoder(buffer)
+    return decoder.Decode(out)
+}
@@ -0,0 +1,58 @@
+package pubsub
+
+import (
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "sync"
+    "github.com/klauspost/compress/zstd"
+)
+
+// Compressions, sent as the message's content encoding.
+const (
+    CompressionNone = ""
+    CompressionGzip = "gzip"
+    CompressionZstd = "zstd"
+)
+
+// zstd encoders and decoders are expensive to create and safe to share.
+var (
+    zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+    zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+)
+
+func compress(body []byte, compression string) ([]byte, error) {
+    switch compression {
+    case CompressionNone: return body, nil
+    case CompressionGzip:
+        var buffer bytes.Buffer
+        writer := gzip.NewWriter(&buffer)
+        if _, err := writer.Write(body); err != nil { return nil, err }
+        if err := writer.Close(); err != nil { return nil, err }
+        return buffer.Bytes(), nil
+    case CompressionZstd:
+        encoder, err := zstdEncoder()
+        if err != nil { return nil, err }
+        return encoder.EncodeAll(body, nil), nil
+    default:
+        return nil, fmt.Errorf("unknown compression %q", compression)
+    }
+}
+
+func decompress(body []byte, encoding string) ([]byte, error) {
+    switch encoding {
+    case CompressionNone: return body, nil
+    case CompressionGzip:
+        reader, err := gzip.NewReader(bytes.NewReader(body))
+        if err != nil { return nil, err }
+        defer reader.Close()
+        return io.ReadAll(reader)
+    case CompressionZstd:
+        decoder, err := zstdDecoder()
+        if err != nil { return nil, err }
+        return decoder.DecodeAll(body, nil)
+    default:
+        return nil, fmt.Errorf("unknown content encoding %q", encoding)
+    }
+}
@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+    ErrUnroutable = errors.New("no queue is bound to receive the message")
+    ErrNacked = errors.New("broker refused the message")
+    ErrChannelClosed = errors.New("channel closed before the broker confirmed the message")
+)
+
+// PublishError is returned by PublishConfirmed when the broker did not take
+// responsibility for a message. Err is ErrUnroutable or ErrNacked, or, when
+// the message may or may not have been delivered, ErrChannelClosed if the
+// channel or connection was lost first, or the context error if the
+// confirmation did not arrive in time. Those are worth publishing again.
+type PublishError struct {
+    Exchange string
+    Key string
+    ReplyCode uint16
+    ReplyText string
+    Err error
+}
+
+func (e *PublishError) Error() string {
+    if e.ReplyText != "" {
+        return fmt.Sprintf("publish to %v with key %v: %v (%v)", e.Exchange, e.Key, e.Err, e.ReplyText)
+    }
+    return fmt.Sprintf("publish to %v with key %v: %v", e.Exchange, e.Key, e.Err)
+}
+
+func (e *PublishError) Unwrap() error { return e.Err }
+
+// ConfirmPublisher publishes mandatory messages and waits until the broker
+// has either confirmed or returned them.
+type ConfirmPublisher interface {
+    PublishConfirmed(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+}
+
+type confirmedPublisher struct {
+    publisher ConfirmPublisher
+    timeout time.Duration
+}
+
+// WithConfirms wraps publisher so that every Publish is confirmed, giving up
+// after timeout. Use it with PublishJSON and PublishGob where losing a
+// message silently is not acceptable.
+func WithConfirms(publisher ConfirmPublisher, timeout time.Duration) Publisher {
+    return confirmedPublisher { publisher: publisher, timeout: timeout }
+}
+
+func (p confirmedPublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+    if p.timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, p.timeout)
+        defer cancel()
+    }
+    return p.publisher.PublishConfirmed(ctx, exchange, key, msg)
+}
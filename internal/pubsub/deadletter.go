@@ -0,0 +1,82 @@
+package pubsub
+
+import (
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Every queue made by DeclareAndBind dead-letters to DeadLetterExchange, which
+// fans out to DeadLetterQueue. Dead-lettered messages keep their routing key.
+const (
+    DeadLetterExchange = "peril_dlx"
+    DeadLetterQueue = "peril_dlq"
+)
+
+// DeadLetterTopology is the dead-letter exchange and queue.
+func DeadLetterTopology() Topology {
+    return Topology {
+        Exchanges: []ExchangeSpec {
+            { Name: DeadLetterExchange, Kind: amqp.ExchangeFanout, Durable: true },
+        },
+        Queues: []QueueSpec {
+            { Name: DeadLetterQueue, Durable: true },
+        },
+        Bindings: []BindingSpec {
+            { Queue: DeadLetterQueue, Exchange: DeadLetterExchange },
+        },
+    }
+}
+
+// DeclareDeadLetter declares the dead-letter exchange and queue.
+func DeclareDeadLetter(subscriber Subscriber) error {
+    return DeadLetterTopology().Apply(subscriber)
+}
+
+// Death is one entry of the x-death header RabbitMQ adds each time a message
+// is dead-lettered from a queue for a reason.
+type Death struct {
+    Queue string
+    Reason string
+    Exchange string
+    RoutingKeys []string
+    Count int64
+    Time time.Time
+}
+
+// Deaths parses the x-death header, most recent death first.
+func Deaths(headers amqp.Table) []Death {
+    entries, _ := headers["x-death"].([]interface{})
+    deaths := []Death {}
+    for _, entry := range entries {
+        table, ok := entry.(amqp.Table)
+        if !ok { continue }
+        death := Death {}
+        death.Queue, _ = table["queue"].(string)
+        death.Reason, _ = table["reason"].(string)
+        death.Exchange, _ = table["exchange"].(string)
+        death.Count, _ = table["count"].(int64)
+        death.Time, _ = table["time"].(time.Time)
+        keys, _ := table["routing-keys"].([]interface{})
+        for _, key := range keys {
+            if key, ok := key.(string); ok {
+                death.RoutingKeys = append(death.RoutingKeys, key)
+            }
+        }
+        deaths = append(deaths, death)
+    }
+    return deaths
+}
+
+// OriginalRoute returns the exchange and routing key a dead-lettered message
+// was first published with.
+func OriginalRoute(headers amqp.Table) (exchange, key string, ok bool) {
+    exchange, hasExchange := headers[HeaderOriginalExchange].(string)
+    key, hasKey := headers[HeaderOriginalRoutingKey].(string)
+    if hasExchange && hasKey { return exchange, key, true }
+
+    deaths := Deaths(headers)
+    if len(deaths) == 0 { return "", "", false }
+    first := deaths[len(deaths) - 1]
+    if len(first.RoutingKeys) == 0 { return "", "", false }
+    return first.Exchange, first.RoutingKeys[0], true
+}
@@ -0,0 +1,87 @@
+package pubsub
+
+import (
+    "container/list"
+    "fmt"
+    "sync"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DedupeStore remembers the IDs of messages that have been handled. It must
+// be safe for concurrent use.
+type DedupeStore interface {
+    Seen(id string) (bool, error)
+    Record(id string) error
+}
+
+// Dedupe acks messages whose ID is already in store without calling the
+// handler, and records a message's ID once its handler acks it. Messages
+// that are requeued, retried or discarded are not recorded, so they are
+// handled again when they come back. Messages without an ID are always
+// handled. Give each subscription its own store, since the same message is
+// delivered once to every queue it is routed to.
+func Dedupe(store DedupeStore) Middleware {
+    return func(next Handler) Handler {
+        return func(message amqp.Delivery) AckType {
+            if message.MessageId == "" { return next(message) }
+
+            seen, err := store.Seen(message.MessageId)
+            if err != nil {
+                fmt.Printf("Failed to look up message %v, handling it anyway: %v\n", message.MessageId, err)
+            }
+            if seen {
+                fmt.Printf("Skipped duplicate message %v\n", message.MessageId)
+                return AckTypeAck
+            }
+
+            ack := next(message)
+            if ack == AckTypeAck {
+                if err := store.Record(message.MessageId); err != nil {
+                    fmt.Printf("Failed to record message %v: %v\n", message.MessageId, err)
+                }
+            }
+            return ack
+        }
+    }
+}
+
+// LRUStore is a DedupeStore that keeps the most recently recorded IDs in
+// memory, forgetting the oldest once it holds capacity of them.
+type LRUStore struct {
+    mu sync.Mutex
+    capacity int
+    order *list.List
+    entries map[string]*list.Element
+}
+
+func NewLRUStore(capacity int) *LRUStore {
+    return &LRUStore {
+        capacity: capacity,
+        order: list.New(),
+        entries: map[string]*list.Element {},
+    }
+}
+
+func (s *LRUStore) Seen(id string) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    element, ok := s.entries[id]
+    if ok { s.order.MoveToFront(element) }
+    return ok, nil
+}
+
+func (s *LRUStore) Record(id string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if element, ok := s.entries[id]; ok {
+        s.order.MoveToFront(element)
+        return nil
+    }
+    s.entries[id] = s.order.PushFront(id)
+    for s.order.Len() > s.capacity {
+        oldest := s.order.Back()
+        s.order.Remove(oldest)
+        delete(s.entries, oldest.Value.(string))
+    }
+    return nil
+}
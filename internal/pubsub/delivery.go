@@ -0,0 +1,123 @@
+package pubsub
+
+import (
+    "context"
+    "crypto/rand"
+    "fmt"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Quorum queues count how many times a message has been returned to the
+// queue in this header. Classic queues only set the redelivered flag.
+const headerDeliveryCount = "x-delivery-count"
+
+// Delivery is a decoded message together with its metadata.
+type Delivery[T any] struct {
+    Body T
+    // Context carries the delivery's trace span. Pass it to PublishContext
+    // when publishing in response to the delivery.
+    Context context.Context
+    MessageID string
+    Timestamp time.Time
+    AppID string
+    Exchange string
+    RoutingKey string
+    Headers amqp.Table
+    Redelivered bool
+    // DeliveryCount is how many times this message has been delivered,
+    // counting this delivery and every delayed retry. A classic queue only
+    // says whether a message was requeued before, not how often, so the
+    // count is a lower bound there.
+    DeliveryCount int
+}
+
+func newDelivery[T any](ctx context.Context, message amqp.Delivery, body T) Delivery[T] {
+    return Delivery[T] {
+        Body: body,
+        Context: ctx,
+        MessageID: message.MessageId,
+        Timestamp: message.Timestamp,
+        AppID: message.AppId,
+        Exchange: message.Exchange,
+        RoutingKey: message.RoutingKey,
+        Headers: message.Headers,
+        Redelivered: message.Redelivered,
+        DeliveryCount: deliveryCount(message),
+    }
+}
+
+func deliveryCount(message amqp.Delivery) int {
+    count := 1 + retryAttempt(message.Headers)
+    if returned, ok := tableInt(message.Headers, headerDeliveryCount); ok {
+        return count + int(returned)
+    }
+    if message.Redelivered { count++ }
+    return count
+}
+
+// SubscribeDelivery is like Subscribe, but hands the handler the message's
+// metadata along with its body.
+func SubscribeDelivery[T any](
+    ctx context.Context,
+    subscriber Subscriber,
+    exchange,
+    queueName,
+    key string,
+    queueType QueueType,
+    handler func(Delivery[T]) AckType,
+    opts ...SubscribeOption,
+) (*Subscription, error) {
+    return subscribe(
+        ctx,
+        subscriber,
+        exchange,
+        queueName,
+        key,
+        queueType,
+        func(ctx context.Context, message amqp.Delivery, body T) AckType {
+            return handler(newDelivery(ctx, message, body))
+        },
+        decodeByContentType[T],
+        opts,
+    )
+}
+
+type metadataPublisher struct {
+    publisher Publisher
+    appID string
+    headers amqp.Table
+}
+
+// WithMetadata wraps publisher so that every message gets a fresh message
+// ID, the current time, appID and headers, unless the publishing already
+// sets them.
+func WithMetadata(publisher Publisher, appID string, headers amqp.Table) Publisher {
+    return metadataPublisher { publisher: publisher, appID: appID, headers: headers }
+}
+
+func (p metadataPublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+    if msg.MessageId == "" { msg.MessageId = NewMessageID() }
+    if msg.Timestamp.IsZero() { msg.Timestamp = time.Now() }
+    if msg.AppId == "" { msg.AppId = p.appID }
+    if len(p.headers) > 0 {
+        headers := amqp.Table {}
+        for k, v := range p.headers {
+            headers[k] = v
+        }
+        for k, v := range msg.Headers {
+            headers[k] = v
+        }
+        msg.Headers = headers
+    }
+    return p.publisher.Publish(ctx, exchange, key, msg)
+}
+
+// NewMessageID returns a random version 4 UUID.
+func NewMessageID() string {
+    var id [16]byte
+    rand.Read(id[:])
+    id[6] = id[6] & 0x0f | 0x40
+    id[8] = id[8] & 0x3f | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
@@ -0,0 +1,4 @@
+package pubsub
+
+// CodecByName gives the external tests the registered codecs.
+var CodecByName = codecByName
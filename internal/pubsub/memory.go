@@ -0,0 +1,760 @@
+package pubsub
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryBroker is an in-process stand-in for a RabbitMQ server. It routes
+// through direct, topic and fanout exchanges, keeps durable queues across
+// connections, deletes exclusive and auto-delete queues like RabbitMQ does,
+// expires messages by x-message-ttl and per-message expiration, and
+// dead-letters rejected and expired messages through x-dead-letter-exchange.
+type MemoryBroker struct {
+    mu sync.Mutex
+    exchanges map[string]*memoryExchange
+    queues map[string]*memoryQueue
+    queueSeq int
+    consumerSeq int
+}
+
+type memoryExchange struct {
+    name string
+    kind string
+    durable bool
+    bindings []memoryBinding
+}
+
+type memoryBinding struct {
+    queueName string
+    key string
+}
+
+type memoryMessage struct {
+    exchange string
+    key string
+    publishing amqp.Publishing
+    redelivered bool
+    expires time.Time
+}
+
+type memoryQueue struct {
+    name string
+    durable bool
+    autoDelete bool
+    exclusive bool
+    owner *MemoryConnection
+    args amqp.Table
+    messages []memoryMessage
+    consumers []*memoryConsumer
+    nextConsumer int
+    hadConsumer bool
+}
+
+func NewMemoryBroker() *MemoryBroker {
+    return &MemoryBroker {
+        exchanges: map[string]*memoryExchange {
+            "": { name: "", kind: amqp.ExchangeDirect, durable: true },
+        },
+        queues: map[string]*memoryQueue {},
+    }
+}
+
+// Connect opens a connection to the broker. Exclusive queues declared through
+// the connection are deleted when it is closed.
+func (b *MemoryBroker) Connect() *MemoryConnection {
+    return &MemoryConnection {
+        broker: b,
+        consumers: map[*memoryConsumer]struct{} {},
+    }
+}
+
+func notFound(what, name string) error {
+    return &amqp.Error {
+        Code: amqp.NotFound,
+        Reason: fmt.Sprintf("NOT_FOUND - no %v '%v'", what, name),
+    }
+}
+
+func topicMatches(pattern, key string) bool {
+    return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+    if len(pattern) == 0 { return len(words) == 0 }
+    switch pattern[0] {
+    case "#":
+        for i := 0; i <= len(words); i++ {
+            if matchWords(pattern[1:], words[i:]) { return true }
+        }
+        return false
+    case "*":
+        return len(words) > 0 && matchWords(pattern[1:], words[1:])
+    default:
+        return len(words) > 0 && words[0] == pattern[0] && matchWords(pattern[1:], words[1:])
+    }
+}
+
+func (e *memoryExchange) matches(bindingKey, key string) bool {
+    switch e.kind {
+    case amqp.ExchangeFanout: return true
+    case amqp.ExchangeTopic: return topicMatches(bindingKey, key)
+    default: return bindingKey == key
+    }
+}
+
+// route delivers a message to every queue bound to exchange with a matching
+// key and reports whether any queue received it. Must be called with b.mu held.
+func (b *MemoryBroker) route(exchange, key string, msg amqp.Publishing) (bool, error) {
+    if exchange == "" {
+        queue, ok := b.queues[key]
+        if !ok { return false, nil }
+        b.enqueue(queue, memoryMessage { exchange: exchange, key: key, publishing: msg })
+        return true, nil
+    }
+
+    ex, ok := b.exchanges[exchange]
+    if !ok { return false, notFound("exchange", exchange) }
+
+    matched := map[string]struct{} {}
+    for _, binding := range ex.bindings {
+        if ex.matches(binding.key, key) {
+            matched[binding.queueName] = struct{} {}
+        }
+    }
+    for queueName := range matched {
+        b.enqueue(b.queues[queueName], memoryMessage { exchange: exchange, key: key, publishing: msg })
+    }
+    return len(matched) > 0, nil
+}
+
+// tableInt reads an integer queue argument, which clients may send as any
+// integer type.
+func tableInt(table amqp.Table, key string) (int64, bool) {
+    switch v := table[key].(type) {
+    case int: return int64(v), true
+    case int16: return int64(v), true
+    case int32: return int64(v), true
+    case int64: return v, true
+    default: return 0, false
+    }
+}
+
+// Queue arguments that must match when a queue is declared again.
+var equivalentQueueArgs = []string {
+    "x-dead-letter-exchange",
+    "x-dead-letter-routing-key",
+    "x-message-ttl",
+    "x-max-length",
+    "x-single-active-consumer",
+}
+
+func equivalentArgs(a, b amqp.Table) bool {
+    for _, key := range equivalentQueueArgs {
+        aInt, aIsInt := tableInt(a, key)
+        bInt, bIsInt := tableInt(b, key)
+        if aIsInt || bIsInt {
+            if aIsInt != bIsInt || aInt != bInt { return false }
+            continue
+        }
+        aBool, _ := a[key].(bool)
+        bBool, _ := b[key].(bool)
+        if aBool != bBool { return false }
+        aString, aIsString := a[key].(string)
+        bString, bIsString := b[key].(string)
+        if aIsString != bIsString || aString != bString { return false }
+    }
+    return true
+}
+
+// messageTTL is the shorter of the queue's x-message-ttl and the message's
+// expiration, if either is set.
+func messageTTL(queue *memoryQueue, publishing amqp.Publishing) (time.Duration, bool) {
+    ttl, ok := tableInt(queue.args, "x-message-ttl")
+    if expiration, err := strconv.ParseInt(publishing.Expiration, 10, 64); err == nil {
+        if !ok || expiration < ttl { ttl = expiration }
+        ok = true
+    }
+    return time.Duration(ttl) * time.Millisecond, ok
+}
+
+func (b *MemoryBroker) enqueue(queue *memoryQueue, message memoryMessage) {
+    if ttl, ok := messageTTL(queue, message.publishing); ok {
+        message.expires = time.Now().Add(ttl)
+        b.scheduleExpiry(queue, message.expires)
+    }
+    queue.messages = append(queue.messages, message)
+    b.dispatch(queue)
+
+    // Like RabbitMQ's default overflow behaviour, a full queue drops or
+    // dead-letters its oldest ready messages.
+    if maxLength, ok := tableInt(queue.args, "x-max-length"); ok {
+        for int64(len(queue.messages)) > maxLength {
+            b.deadLetter(queue, queue.messages[0], "maxlen")
+            queue.messages = queue.messages[1:]
+        }
+    }
+}
+
+func (b *MemoryBroker) scheduleExpiry(queue *memoryQueue, expires time.Time) {
+    time.AfterFunc(time.Until(expires), func() {
+        b.mu.Lock()
+        defer b.mu.Unlock()
+        b.expire(queue)
+    })
+}
+
+// expire dead-letters the messages waiting in queue whose time is up.
+// Messages already handed to a consumer are not expired.
+func (b *MemoryBroker) expire(queue *memoryQueue) {
+    if b.queues[queue.name] != queue { return }
+    now := time.Now()
+    waiting := []memoryMessage {}
+    for _, message := range queue.messages {
+        if !message.expires.IsZero() && !message.expires.After(now) {
+            b.deadLetter(queue, message, "expired")
+            continue
+        }
+        waiting = append(waiting, message)
+    }
+    queue.messages = waiting
+}
+
+// requeue puts messages back at the head of their queue, in order.
+func (b *MemoryBroker) requeue(queue *memoryQueue, messages []memoryMessage) {
+    if len(messages) == 0 { return }
+    for i := range messages {
+        messages[i].redelivered = true
+        if !messages[i].expires.IsZero() {
+            b.scheduleExpiry(queue, messages[i].expires)
+        }
+    }
+    queue.messages = append(messages, queue.messages...)
+    b.dispatch(queue)
+}
+
+// dispatch hands ready messages to consumers round robin, respecting each
+// consumer's prefetch limit. A queue with x-single-active-consumer only
+// delivers to its oldest consumer.
+func (b *MemoryBroker) dispatch(queue *memoryQueue) {
+    consumers := queue.consumers
+    if singleActive, _ := queue.args["x-single-active-consumer"].(bool); singleActive && len(consumers) > 1 {
+        consumers = consumers[:1]
+        queue.nextConsumer = 0
+    }
+    for len(queue.messages) > 0 {
+        var consumer *memoryConsumer
+        for i := range consumers {
+            candidate := consumers[(queue.nextConsumer + i) % len(consumers)]
+            if candidate.hasCapacity() {
+                consumer = candidate
+                queue.nextConsumer = (queue.nextConsumer + i + 1) % len(consumers)
+                break
+            }
+        }
+        if consumer == nil { return }
+
+        message := queue.messages[0]
+        queue.messages = queue.messages[1:]
+        consumer.push(message)
+    }
+}
+
+// deadLetter republishes a message to the queue's dead-letter exchange, if it
+// has one, recording why in the x-death header. Like RabbitMQ, messages the
+// dead-letter exchange cannot route are dropped.
+func (b *MemoryBroker) deadLetter(queue *memoryQueue, message memoryMessage, reason string) {
+    dlx, ok := queue.args["x-dead-letter-exchange"].(string)
+    if !ok { return }
+    key := message.key
+    if dlKey, ok := queue.args["x-dead-letter-routing-key"].(string); ok { key = dlKey }
+
+    publishing := message.publishing
+    publishing.Headers = amqp.Table {}
+    for k, v := range message.publishing.Headers {
+        publishing.Headers[k] = v
+    }
+    publishing.Expiration = ""
+    recordDeath(publishing.Headers, queue.name, reason, message.exchange, message.key, message.publishing.Expiration)
+
+    b.route(dlx, key, publishing)
+}
+
+func recordDeath(headers amqp.Table, queueName, reason, exchange, key, expiration string) {
+    deaths, _ := headers["x-death"].([]interface{})
+    updated := []interface{} {}
+    var death amqp.Table
+    for _, entry := range deaths {
+        table, ok := entry.(amqp.Table)
+        if ok && death == nil && table["queue"] == queueName && table["reason"] == reason {
+            death = amqp.Table {}
+            for k, v := range table {
+                death[k] = v
+            }
+            count, _ := death["count"].(int64)
+            death["count"] = count + 1
+            death["time"] = time.Now()
+            continue
+        }
+        updated = append(updated, entry)
+    }
+    if death == nil {
+        death = amqp.Table {
+            "count": int64(1),
+            "reason": reason,
+            "queue": queueName,
+            "time": time.Now(),
+            "exchange": exchange,
+            "routing-keys": []interface{} { key },
+        }
+        if expiration != "" { death["original-expiration"] = expiration }
+    }
+    headers["x-death"] = append([]interface{} { death }, updated...)
+
+    if _, ok := headers["x-first-death-queue"]; !ok {
+        headers["x-first-death-queue"] = queueName
+        headers["x-first-death-reason"] = reason
+        headers["x-first-death-exchange"] = exchange
+    }
+}
+
+// deleteQueue removes a queue and its bindings. Must be called with b.mu held.
+func (b *MemoryBroker) deleteQueue(queue *memoryQueue) {
+    delete(b.queues, queue.name)
+    for _, ex := range b.exchanges {
+        bindings := ex.bindings[:0]
+        for _, binding := range ex.bindings {
+            if binding.queueName != queue.name {
+                bindings = append(bindings, binding)
+            }
+        }
+        ex.bindings = bindings
+    }
+    consumers := append([]*memoryConsumer {}, queue.consumers...)
+    for _, consumer := range consumers {
+        consumer.cancel()
+    }
+}
+
+// MemoryConnection is a Broker connected to a MemoryBroker.
+type MemoryConnection struct {
+    broker *MemoryBroker
+    consumers map[*memoryConsumer]struct{}
+    closed bool
+}
+
+func (c *MemoryConnection) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+    if err := ctx.Err(); err != nil { return err }
+    c.broker.mu.Lock()
+    defer c.broker.mu.Unlock()
+    if c.closed { return amqp.ErrClosed }
+    _, err := c.broker.route(exchange, key, msg)
+    return err
+}
+
+func (c *MemoryConnection) PublishConfirmed(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+    if err := ctx.Err(); err != nil {
+        return &PublishError { Exchange: exchange, Key: key, Err: err }
+    }
+    c.broker.mu.Lock()
+    defer c.broker.mu.Unlock()
+    if c.closed { return amqp.ErrClosed }
+    routed, err := c.broker.route(exchange, key, msg)
+    if err != nil { return err }
+    if !routed {
+        return &PublishError {
+            Exchange: exchange,
+            Key: key,
+            ReplyCode: amqp.NoRoute,
+            ReplyText: "NO_ROUTE",
+            Err: ErrUnroutable,
+        }
+    }
+    return nil
+}
+
+func (c *MemoryConnection) ExchangeDeclare(name, kind string, durable bool) error {
+    switch kind {
+    case amqp.ExchangeDirect, amqp.ExchangeTopic, amqp.ExchangeFanout:
+    default:
+        return &amqp.Error {
+            Code: amqp.NotImplemented,
+            Reason: fmt.Sprintf("NOT_IMPLEMENTED - exchange type '%v'", kind),
+        }
+    }
+
+    c.broker.mu.Lock()
+    defer c.broker.mu.Unlock()
+    if c.closed { return amqp.ErrClosed }
+    if ex, ok := c.broker.exchanges[name]; ok {
+        if ex.kind != kind || ex.durable != durable {
+            return &amqp.Error {
+                Code: amqp.PreconditionFailed,
+                Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg for exchange '%v'", name),
+            }
+        }
+        return nil
+    }
+    c.broker.exchanges[name] = &memoryExchange { name: name, kind: kind, durable: durable }
+    return nil
+}
+
+func (c *MemoryConnection) QueueDeclare(
+    name string,
+    durable,
+    autoDelete,
+    exclusive bool,
+    args amqp.Table,
+) (amqp.Queue, error) {
+    c.broker.mu.Lock()
+    defer c.broker.mu.Unlock()
+    if c.closed { return amqp.Queue {}, amqp.ErrClosed }
+
+    if name == "" {
+        c.broker.queueSeq++
+        name = fmt.Sprintf("amq.gen-%v", c.broker.queueSeq)
+    }
+
+    queue, ok := c.broker.queues[name]
+    if ok {
+        if queue.exclusive && queue.owner != c {
+            return amqp.Queue {}, &amqp.Error {
+                Code: amqp.ResourceLocked,
+                Reason: fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to queue '%v'", name),
+            }
+        }
+        if queue.durable != durable ||
+            queue.autoDelete != autoDelete ||
+            queue.exclusive != exclusive ||
+            !equivalentArgs(queue.args, args) {
+            return amqp.Queue {}, &amqp.Error {
+                Code: amqp.PreconditionFailed,
+                Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg for queue '%v'", name),
+            }
+        }
+    } else {
+        queue = &memoryQueue {
+            name: name,
+            durable: durable,
+            autoDelete: autoDelete,
+            exclusive: exclusive,
+            args: args,
+        }
+        if exclusive { queue.owner = c }
+        c.broker.queues[name] = queue
+    }
+
+    return amqp.Queue { Name: name, Messages: len(queue.messages), Consumers: len(queue.consumers) }, nil
+}
+
+func (c *MemoryConnection) QueueBind(queueName, key, exchange string) error {
+    c.broker.mu.Lock()
+    defer c.broker.mu.Unlock()
+    if c.closed { return amqp.ErrClosed }
+
+    queue, ok := c.broker.queues[queueName]
+    if !ok { return notFound("queue", queueName) }
+    if queue.exclusive && queue.owner != c {
+        return &amqp.Error {
+            Code: amqp.ResourceLocked,
+            Reason: fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to queue '%v'", queueName),
+        }
+    }
+    ex, ok := c.broker.exchanges[exchange]
+    if !ok || exchange == "" { return notFound("exchange", exchange) }
+
+    for _, binding := range ex.bindings {
+        if binding.queueName == queueName && binding.key == key { return nil }
+    }
+    ex.bindings = append(ex.bindings, memoryBinding { queueName: queueName, key: key })
+    return nil
+}
+
+func (c *MemoryConnection) QueueDelete(name string) error {
+    c.broker.mu.Lock()
+    defer c.broker.mu.Unlock()
+    if c.closed { return amqp.ErrClosed }
+
+    queue, ok := c.broker.queues[name]
+    if !ok { return nil }
+    if queue.exclusive && queue.owner != c {
+        return &amqp.Error {
+            Code: amqp.ResourceLocked,
+            Reason: fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to queue '%v'", name),
+        }
+    }
+    c.broker.deleteQueue(queue)
+    return nil
+}
+
+func (c *MemoryConnection) Consume(queueName string, prefetch int) (Consumer, error) {
+    c.broker.mu.Lock()
+    defer c.broker.mu.Unlock()
+    if c.closed { return nil, amqp.ErrClosed }
+
+    queue, ok := c.broker.queues[queueName]
+    if !ok { return nil, notFound("queue", queueName) }
+    if queue.exclusive && queue.owner != c {
+        return nil, &amqp.Error {
+            Code: amqp.ResourceLocked,
+            Reason: fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to queue '%v'", queueName),
+        }
+    }
+
+    c.broker.consumerSeq++
+    consumer := &memoryConsumer {
+        connection: c,
+        queue: queue,
+        tag: fmt.Sprintf("ctag-%v", c.broker.consumerSeq),
+        prefetch: prefetch,
+        unacked: map[uint64]memoryMessage {},
+        deliveries: make(chan amqp.Delivery),
+        done: make(chan struct{}),
+    }
+    consumer.wake = sync.NewCond(&c.broker.mu)
+    queue.consumers = append(queue.consumers, consumer)
+    queue.hadConsumer = true
+    c.consumers[consumer] = struct{} {}
+
+    go consumer.pump()
+    c.broker.dispatch(queue)
+    return consumer, nil
+}
+
+// Close cancels the connection's consumers, requeueing anything they left
+// unacked, and deletes the exclusive queues it declared.
+func (c *MemoryConnection) Close() error {
+    c.broker.mu.Lock()
+    defer c.broker.mu.Unlock()
+    if c.closed { return nil }
+    c.closed = true
+
+    for consumer := range c.consumers {
+        consumer.close()
+    }
+    for _, queue := range c.broker.queues {
+        if queue.exclusive && queue.owner == c {
+            c.broker.deleteQueue(queue)
+        }
+    }
+    return nil
+}
+
+// memoryConsumer implements amqp.Acknowledger for the deliveries it hands out.
+// All of its state is guarded by the broker's mutex.
+type memoryConsumer struct {
+    connection *MemoryConnection
+    queue *memoryQueue
+    tag string
+    prefetch int
+    deliveryTag uint64
+    unacked map[uint64]memoryMessage
+    outbox []amqp.Delivery
+    wake *sync.Cond
+    cancelled bool
+    closed bool
+    deliveries chan amqp.Delivery
+    done chan struct{}
+}
+
+func (c *memoryConsumer) Deliveries() <-chan amqp.Delivery {
+    return c.deliveries
+}
+
+func (c *memoryConsumer) Cancel() error {
+    c.connection.broker.mu.Lock()
+    defer c.connection.broker.mu.Unlock()
+    c.cancel()
+    return nil
+}
+
+func (c *memoryConsumer) Close() error {
+    c.connection.broker.mu.Lock()
+    defer c.connection.broker.mu.Unlock()
+    c.close()
+    return nil
+}
+
+func (c *memoryConsumer) hasCapacity() bool {
+    if c.cancelled { return false }
+    return c.prefetch <= 0 || len(c.unacked) < c.prefetch
+}
+
+func (c *memoryConsumer) push(message memoryMessage) {
+    c.deliveryTag++
+    c.unacked[c.deliveryTag] = message
+    publishing := message.publishing
+    c.outbox = append(c.outbox, amqp.Delivery {
+        Acknowledger: c,
+        Headers: publishing.Headers,
+        ContentType: publishing.ContentType,
+        ContentEncoding: publishing.ContentEncoding,
+        DeliveryMode: publishing.DeliveryMode,
+        Priority: publishing.Priority,
+        CorrelationId: publishing.CorrelationId,
+        ReplyTo: publishing.ReplyTo,
+        Expiration: publishing.Expiration,
+        MessageId: publishing.MessageId,
+        Timestamp: publishing.Timestamp,
+        Type: publishing.Type,
+        UserId: publishing.UserId,
+        AppId: publishing.AppId,
+        ConsumerTag: c.tag,
+        DeliveryTag: c.deliveryTag,
+        Redelivered: message.redelivered,
+        Exchange: message.exchange,
+        RoutingKey: message.key,
+        Body: publishing.Body,
+    })
+    c.wake.Signal()
+}
+
+// pump feeds the outbox to the delivery channel without holding the broker
+// lock, so handlers are free to ack while the next delivery is waiting.
+func (c *memoryConsumer) pump() {
+    mu := &c.connection.broker.mu
+    defer close(c.deliveries)
+    for {
+        mu.Lock()
+        for len(c.outbox) == 0 && !c.cancelled {
+            c.wake.Wait()
+        }
+        if c.cancelled {
+            mu.Unlock()
+            return
+        }
+        delivery := c.outbox[0]
+        c.outbox = c.outbox[1:]
+        mu.Unlock()
+
+        select {
+        case c.deliveries <- delivery:
+        case <-c.done:
+            // Cancelled before anyone received it, so it goes back in front
+            // of whatever cancel already requeued.
+            mu.Lock()
+            if message, ok := c.unacked[delivery.DeliveryTag]; ok {
+                delete(c.unacked, delivery.DeliveryTag)
+                c.connection.broker.requeue(c.queue, []memoryMessage { message })
+            }
+            mu.Unlock()
+            return
+        }
+    }
+}
+
+// cancel stops new deliveries and requeues the ones not yet received. Already
+// received deliveries can still be acked. Must be called with the broker lock.
+func (c *memoryConsumer) cancel() {
+    if c.cancelled { return }
+    c.cancelled = true
+    close(c.done)
+    c.wake.Signal()
+
+    requeued := []memoryMessage {}
+    for _, delivery := range c.outbox {
+        requeued = append(requeued, c.unacked[delivery.DeliveryTag])
+        delete(c.unacked, delivery.DeliveryTag)
+    }
+    c.outbox = nil
+
+    queue := c.queue
+    for i, consumer := range queue.consumers {
+        if consumer == c {
+            queue.consumers = append(queue.consumers[:i], queue.consumers[i+1:]...)
+            break
+        }
+    }
+    broker := c.connection.broker
+    if broker.queues[queue.name] != queue { return }
+    if queue.autoDelete && queue.hadConsumer && len(queue.consumers) == 0 {
+        broker.deleteQueue(queue)
+        return
+    }
+    broker.requeue(queue, requeued)
+}
+
+// close cancels the consumer and requeues everything it left unacked, as
+// closing an AMQP channel does. Must be called with the broker lock.
+func (c *memoryConsumer) close() {
+    if c.closed { return }
+    c.cancel()
+    c.closed = true
+    delete(c.connection.consumers, c)
+    if c.connection.broker.queues[c.queue.name] == c.queue {
+        c.connection.broker.requeue(c.queue, c.takeUnacked(^uint64(0), true))
+    }
+}
+
+// takeUnacked removes and returns the unacked messages up to tag, in delivery
+// order. Without multiple only the message with exactly that tag is taken.
+func (c *memoryConsumer) takeUnacked(tag uint64, multiple bool) []memoryMessage {
+    if !multiple {
+        message, ok := c.unacked[tag]
+        if !ok { return nil }
+        delete(c.unacked, tag)
+        return []memoryMessage { message }
+    }
+
+    tags := []uint64 {}
+    for t := range c.unacked {
+        if t <= tag { tags = append(tags, t) }
+    }
+    sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+    messages := []memoryMessage {}
+    for _, t := range tags {
+        messages = append(messages, c.unacked[t])
+        delete(c.unacked, t)
+    }
+    return messages
+}
+
+func (c *memoryConsumer) Ack(tag uint64, multiple bool) error {
+    broker := c.connection.broker
+    broker.mu.Lock()
+    defer broker.mu.Unlock()
+    if c.closed { return amqp.ErrClosed }
+    if len(c.takeUnacked(tag, multiple)) == 0 && !multiple {
+        return &amqp.Error {
+            Code: amqp.PreconditionFailed,
+            Reason: fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %v", tag),
+        }
+    }
+    broker.dispatch(c.queue)
+    return nil
+}
+
+func (c *memoryConsumer) Nack(tag uint64, multiple bool, requeue bool) error {
+    broker := c.connection.broker
+    broker.mu.Lock()
+    defer broker.mu.Unlock()
+    if c.closed { return amqp.ErrClosed }
+    messages := c.takeUnacked(tag, multiple)
+    if len(messages) == 0 && !multiple {
+        return &amqp.Error {
+            Code: amqp.PreconditionFailed,
+            Reason: fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %v", tag),
+        }
+    }
+    if broker.queues[c.queue.name] != c.queue { return nil }
+
+    if requeue {
+        broker.requeue(c.queue, messages)
+        return nil
+    }
+    for _, message := range messages {
+        broker.deadLetter(c.queue, message, "rejected")
+    }
+    broker.dispatch(c.queue)
+    return nil
+}
+
+func (c *memoryConsumer) Reject(tag uint64, requeue bool) error {
+    return c.Nack(tag, false, requeue)
+}
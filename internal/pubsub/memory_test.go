@@ -0,0 +1,406 @@
+package pubsub
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "testing"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const testTimeout = time.Second
+
+func must(t *testing.T, err error) {
+    t.Helper()
+    if err != nil { t.Fatal(err) }
+}
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+    t.Helper()
+    select {
+    case delivery, ok := <-deliveries:
+        if !ok { t.Fatal("deliveries closed") }
+        return delivery
+    case <-time.After(testTimeout):
+        t.Fatal("timed out waiting for a delivery")
+    }
+    return amqp.Delivery {}
+}
+
+func receiveNothing(t *testing.T, deliveries <-chan amqp.Delivery) {
+    t.Helper()
+    select {
+    case delivery := <-deliveries:
+        t.Fatalf("unexpected delivery %q with key %v", delivery.Body, delivery.RoutingKey)
+    case <-time.After(50 * time.Millisecond):
+    }
+}
+
+// consume declares a queue bound to exchange with key and consumes it.
+func consume(t *testing.T, connection *MemoryConnection, exchange, queueName, key string) <-chan amqp.Delivery {
+    t.Helper()
+    _, err := DeclareAndBind(connection, exchange, queueName, key, DurableQueue)
+    must(t, err)
+    consumer, err := connection.Consume(queueName, 0)
+    must(t, err)
+    return consumer.Deliveries()
+}
+
+func publishBody(t *testing.T, connection *MemoryConnection, exchange, key, body string) {
+    t.Helper()
+    must(t, connection.Publish(context.Background(), exchange, key, amqp.Publishing { Body: []byte(body) }))
+}
+
+func TestTopicMatches(t *testing.T) {
+    tests := []struct {
+        pattern, key string
+        want bool
+    }{
+        { "army_moves.*", "army_moves.bob", true },
+        { "army_moves.*", "army_moves", false },
+        { "army_moves.*", "army_moves.bob.extra", false },
+        { "army_moves.*", "war.bob", false },
+        { "*.bob", "army_moves.bob", true },
+        { "#", "", true },
+        { "#", "a.b.c", true },
+        { "a.#", "a", true },
+        { "a.#", "a.b.c", true },
+        { "a.#", "b.a", false },
+        { "#.c", "a.b.c", true },
+        { "#.b.*", "x.y.b.z", true },
+        { "a.#.c", "a.c", true },
+        { "a.#.c", "a.b.d", false },
+        { "pause", "pause", true },
+    }
+    for _, tt := range tests {
+        if got := topicMatches(tt.pattern, tt.key); got != tt.want {
+            t.Errorf("topicMatches(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+        }
+    }
+}
+
+func TestMemoryBrokerRoutesByExchangeKind(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, connection.ExchangeDeclare("direct", amqp.ExchangeDirect, true))
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+    must(t, connection.ExchangeDeclare("fanout", amqp.ExchangeFanout, true))
+    direct := consume(t, connection, "direct", "direct_q", "pause")
+    topic := consume(t, connection, "topic", "topic_q", "army_moves.*")
+    fanout := consume(t, connection, "fanout", "fanout_q", "ignored")
+
+    publishBody(t, connection, "direct", "pause.bob", "not routed")
+    publishBody(t, connection, "direct", "pause", "direct")
+    publishBody(t, connection, "topic", "war.bob", "not routed")
+    publishBody(t, connection, "topic", "army_moves.bob", "topic")
+    publishBody(t, connection, "fanout", "anything", "fanout")
+    publishBody(t, connection, "", "direct_q", "default exchange")
+
+    for _, tt := range []struct {
+        deliveries <-chan amqp.Delivery
+        bodies []string
+    }{
+        { direct, []string { "direct", "default exchange" } },
+        { topic, []string { "topic" } },
+        { fanout, []string { "fanout" } },
+    } {
+        for _, body := range tt.bodies {
+            if got := string(receive(t, tt.deliveries).Body); got != body {
+                t.Errorf("got %q, want %q", got, body)
+            }
+        }
+        receiveNothing(t, tt.deliveries)
+    }
+}
+
+func TestMemoryBrokerUnroutable(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+
+    err := connection.PublishConfirmed(context.Background(), "topic", "nobody.listens", amqp.Publishing {})
+    if !errors.Is(err, ErrUnroutable) { t.Errorf("PublishConfirmed to no queue = %v, want ErrUnroutable", err) }
+    var amqpErr *amqp.Error
+    err = connection.Publish(context.Background(), "missing", "key", amqp.Publishing {})
+    if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.NotFound {
+        t.Errorf("Publish to a missing exchange = %v, want NOT_FOUND", err)
+    }
+}
+
+func TestDurableQueueOutlivesConnection(t *testing.T) {
+    broker := NewMemoryBroker()
+    first := broker.Connect()
+    must(t, first.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+    _, err := DeclareAndBind(first, "topic", "game_logs", "game_logs.*", DurableQueue)
+    must(t, err)
+    publishBody(t, first, "topic", "game_logs.bob", "log")
+    must(t, first.Close())
+
+    second := broker.Connect()
+    defer second.Close()
+    queue, err := NewQueueSpec("game_logs", DurableQueue).Declare(second)
+    must(t, err)
+    if queue.Messages != 1 { t.Fatalf("durable queue has %v messages after reconnecting, want 1", queue.Messages) }
+    consumer, err := second.Consume("game_logs", 0)
+    must(t, err)
+    if got := string(receive(t, consumer.Deliveries()).Body); got != "log" { t.Errorf("got %q, want %q", got, "log") }
+}
+
+func TestTransientQueueBelongsToConnection(t *testing.T) {
+    broker := NewMemoryBroker()
+    owner := broker.Connect()
+    other := broker.Connect()
+    defer other.Close()
+    must(t, owner.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+    _, err := DeclareAndBind(owner, "topic", "army_moves.bob", "army_moves.*", TransientQueue)
+    must(t, err)
+
+    var amqpErr *amqp.Error
+    _, err = NewQueueSpec("army_moves.bob", TransientQueue).Declare(other)
+    if !errors.As(err, &amqpErr) || amqpErr.Code != amqp.ResourceLocked {
+        t.Errorf("declaring another connection's exclusive queue = %v, want RESOURCE_LOCKED", err)
+    }
+    if _, err := other.Consume("army_moves.bob", 0); !errors.As(err, &amqpErr) || amqpErr.Code != amqp.ResourceLocked {
+        t.Errorf("consuming another connection's exclusive queue = %v, want RESOURCE_LOCKED", err)
+    }
+
+    must(t, owner.Close())
+    err = other.PublishConfirmed(context.Background(), "topic", "army_moves.alice", amqp.Publishing {})
+    if !errors.Is(err, ErrUnroutable) { t.Errorf("publishing after the owner closed = %v, want ErrUnroutable", err) }
+    _, err = NewQueueSpec("army_moves.bob", TransientQueue).Declare(other)
+    must(t, err)
+}
+
+func TestAutoDeleteQueueGoesWithLastConsumer(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    _, err := connection.QueueDeclare("replies", false, true, false, nil)
+    must(t, err)
+    first, err := connection.Consume("replies", 0)
+    must(t, err)
+    second, err := connection.Consume("replies", 0)
+    must(t, err)
+
+    must(t, first.Cancel())
+    must(t, connection.PublishConfirmed(context.Background(), "", "replies", amqp.Publishing {}))
+    must(t, second.Cancel())
+    err = connection.PublishConfirmed(context.Background(), "", "replies", amqp.Publishing {})
+    if !errors.Is(err, ErrUnroutable) { t.Errorf("publishing after the last consumer left = %v, want ErrUnroutable", err) }
+}
+
+func TestQueueDeleteRemovesBindingsAndConsumers(t *testing.T) {
+    broker := NewMemoryBroker()
+    connection := broker.Connect()
+    defer connection.Close()
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+    deliveries := consume(t, connection, "topic", "moves", "army_moves.*")
+
+    must(t, connection.QueueDelete("moves"))
+    if _, ok := <-deliveries; ok { t.Error("consumer still open after its queue was deleted") }
+    err := connection.PublishConfirmed(context.Background(), "topic", "army_moves.bob", amqp.Publishing {})
+    if !errors.Is(err, ErrUnroutable) { t.Errorf("publishing to the deleted queue's binding = %v, want ErrUnroutable", err) }
+    must(t, connection.QueueDelete("moves"))
+
+    _, err = connection.QueueDeclare("replies", false, true, true, nil)
+    must(t, err)
+    other := broker.Connect()
+    defer other.Close()
+    var amqpErr *amqp.Error
+    if err := other.QueueDelete("replies"); !errors.As(err, &amqpErr) || amqpErr.Code != amqp.ResourceLocked {
+        t.Errorf("deleting another connection's exclusive queue = %v, want RESOURCE_LOCKED", err)
+    }
+}
+
+func TestRedeclareWithOtherSettingsFails(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    _, err := NewQueueSpec("intents", DurableQueue).Declare(connection)
+    must(t, err)
+    spec := NewQueueSpec("intents", DurableQueue)
+    spec.SingleActiveConsumer = true
+    var amqpErr *amqp.Error
+    if _, err := spec.Declare(connection); !errors.As(err, &amqpErr) || amqpErr.Code != amqp.PreconditionFailed {
+        t.Errorf("redeclaring with other arguments = %v, want PRECONDITION_FAILED", err)
+    }
+}
+
+func TestAckNackAndRequeue(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, DeclareDeadLetter(connection))
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+    deliveries := consume(t, connection, "topic", "war", "war.*")
+    dlq, err := connection.Consume(DeadLetterQueue, 0)
+    must(t, err)
+
+    publishBody(t, connection, "topic", "war.bob", "requeued")
+    first := receive(t, deliveries)
+    if first.Redelivered { t.Error("first delivery is marked redelivered") }
+    must(t, first.Nack(false, true))
+    again := receive(t, deliveries)
+    if string(again.Body) != "requeued" || !again.Redelivered {
+        t.Errorf("requeued delivery = %q, redelivered %v, want %q redelivered", again.Body, again.Redelivered, "requeued")
+    }
+    must(t, again.Ack(false))
+    var amqpErr *amqp.Error
+    if err := again.Ack(false); !errors.As(err, &amqpErr) || amqpErr.Code != amqp.PreconditionFailed {
+        t.Errorf("acking twice = %v, want PRECONDITION_FAILED", err)
+    }
+    receiveNothing(t, deliveries)
+    receiveNothing(t, dlq.Deliveries())
+
+    publishBody(t, connection, "topic", "war.bob", "discarded")
+    must(t, receive(t, deliveries).Nack(false, false))
+    dead := receive(t, dlq.Deliveries())
+    if string(dead.Body) != "discarded" { t.Errorf("dead-lettered %q, want %q", dead.Body, "discarded") }
+    receiveNothing(t, deliveries)
+}
+
+func TestDeadLetterRecordsOriginalRoute(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, DeclareDeadLetter(connection))
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+    deliveries := consume(t, connection, "topic", "war", "war.*")
+    dlq, err := connection.Consume(DeadLetterQueue, 0)
+    must(t, err)
+
+    publishBody(t, connection, "topic", "war.bob", "rejected")
+    must(t, receive(t, deliveries).Reject(false))
+    dead := receive(t, dlq.Deliveries())
+    if dead.Exchange != DeadLetterExchange || dead.RoutingKey != "war.bob" {
+        t.Errorf("dead letter routed through %v with key %v, want %v with war.bob", dead.Exchange, dead.RoutingKey, DeadLetterExchange)
+    }
+    exchange, key, ok := OriginalRoute(dead.Headers)
+    if !ok || exchange != "topic" || key != "war.bob" {
+        t.Errorf("OriginalRoute = %v, %v, %v, want topic, war.bob, true", exchange, key, ok)
+    }
+    deaths := Deaths(dead.Headers)
+    if len(deaths) != 1 || deaths[0].Queue != "war" || deaths[0].Reason != "rejected" || deaths[0].Count != 1 {
+        t.Errorf("Deaths = %+v, want one rejection from war", deaths)
+    }
+}
+
+func TestExpiredMessagesAreDeadLettered(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, DeclareDeadLetter(connection))
+    spec := NewQueueSpec("short_lived", DurableQueue)
+    spec.MessageTTL = 20 * time.Millisecond
+    _, err := spec.Declare(connection)
+    must(t, err)
+    dlq, err := connection.Consume(DeadLetterQueue, 0)
+    must(t, err)
+
+    publishBody(t, connection, "", "short_lived", "expired")
+    dead := receive(t, dlq.Deliveries())
+    if deaths := Deaths(dead.Headers); len(deaths) != 1 || deaths[0].Reason != "expired" {
+        t.Errorf("Deaths = %+v, want one expiry", deaths)
+    }
+}
+
+func TestSubscribeAckTypes(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+    must(t, DeclareDeadLetter(connection))
+    dlq, err := connection.Consume(DeadLetterQueue, 0)
+    must(t, err)
+
+    var mu sync.Mutex
+    seen := map[string]int {}
+    handled := make(chan string, 10)
+    subscription, err := SubscribeJSON(context.Background(), connection, "topic", "moves", "moves.*", DurableQueue, func(body string) AckType {
+        mu.Lock()
+        defer mu.Unlock()
+        seen[body]++
+        defer func() { handled <- body }()
+        switch {
+        case body == "requeue" && seen[body] == 1: return AckTypeNackRequeue
+        case body == "discard": return AckTypeNackDiscard
+        default: return AckTypeAck
+        }
+    })
+    must(t, err)
+    defer subscription.Close()
+
+    for _, body := range []string { "ack", "requeue", "discard" } {
+        must(t, PublishJSON(connection, "topic", "moves.bob", body))
+    }
+    for range 4 {
+        select {
+        case <-handled:
+        case <-time.After(testTimeout): t.Fatal("timed out waiting for the handler")
+        }
+    }
+    mu.Lock()
+    if seen["ack"] != 1 || seen["requeue"] != 2 || seen["discard"] != 1 {
+        t.Errorf("handled %v, want ack once, requeue twice and discard once", seen)
+    }
+    mu.Unlock()
+    var dead string
+    must(t, Decode(receive(t, dlq.Deliveries()), &dead))
+    if dead != "discard" { t.Errorf("dead-lettered %q, want %q", dead, "discard") }
+}
+
+func TestRetryLaterThenDeadLetter(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+    must(t, DeclareDeadLetter(connection))
+    dlq, err := connection.Consume(DeadLetterQueue, 0)
+    must(t, err)
+
+    attempts := make(chan time.Time, 10)
+    subscription, err := SubscribeJSON(context.Background(), connection, "topic", "war", "war.*", DurableQueue, func(int) AckType {
+        attempts <- time.Now()
+        return AckTypeRetryLater
+    }, WithRetry(connection, 2, 10 * time.Millisecond, 30 * time.Millisecond))
+    must(t, err)
+    defer subscription.Close()
+
+    must(t, PublishJSON(connection, "topic", "war.bob", 1))
+    dead := receive(t, dlq.Deliveries())
+    close(attempts)
+    times := []time.Time {}
+    for attempt := range attempts {
+        times = append(times, attempt)
+    }
+    if len(times) != 3 { t.Fatalf("handled %v times, want the first try and 2 retries", len(times)) }
+    if gap := times[2].Sub(times[1]); gap < 30 * time.Millisecond {
+        t.Errorf("second retry came %v after the first, want at least 30ms", gap)
+    }
+
+    if attempt := retryAttempt(dead.Headers); attempt != 2 { t.Errorf("dead letter has retry attempt %v, want 2", attempt) }
+    exchange, key, ok := OriginalRoute(dead.Headers)
+    if !ok || exchange != "topic" || key != "war.bob" {
+        t.Errorf("OriginalRoute = %v, %v, %v, want topic, war.bob, true", exchange, key, ok)
+    }
+}
+
+func TestRetryLaterWithoutPolicyRequeues(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+
+    attempts := make(chan int, 10)
+    count := 0
+    subscription, err := SubscribeJSON(context.Background(), connection, "topic", "war", "war.*", DurableQueue, func(int) AckType {
+        count++
+        attempts <- count
+        if count < 3 { return AckTypeRetryLater }
+        return AckTypeAck
+    })
+    must(t, err)
+    defer subscription.Close()
+
+    must(t, PublishJSON(connection, "topic", "war.bob", 1))
+    for want := 1; want <= 3; want++ {
+        select {
+        case got := <-attempts:
+            if got != want { t.Fatalf("attempt %v, want %v", got, want) }
+        case <-time.After(testTimeout): t.Fatalf("timed out waiting for attempt %v", want)
+        }
+    }
+}
@@ -0,0 +1,61 @@
+package pubsub
+
+import (
+    "strings"
+    "time"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics are registered with the default Prometheus registry. Routing keys
+// are labelled by their prefix, the part before the first dot, so that
+// per-player keys like army_moves.bob don't each get their own series.
+var (
+    messagesPublished = promauto.NewCounterVec(
+        prometheus.CounterOpts {
+            Name: "peril_messages_published_total",
+            Help: "Messages published.",
+        },
+        []string { "exchange", "key_prefix" },
+    )
+    messagesConsumed = promauto.NewCounterVec(
+        prometheus.CounterOpts {
+            Name: "peril_messages_consumed_total",
+            Help: "Messages received by subscriptions.",
+        },
+        []string { "exchange", "queue", "key_prefix" },
+    )
+    messageOutcomes = promauto.NewCounterVec(
+        prometheus.CounterOpts {
+            Name: "peril_message_outcomes_total",
+            Help: "Handled messages by what the handler asked for.",
+        },
+        []string { "exchange", "queue", "key_prefix", "outcome" },
+    )
+    decodeFailures = promauto.NewCounterVec(
+        prometheus.CounterOpts {
+            Name: "peril_decode_failures_total",
+            Help: "Messages that could not be decoded.",
+        },
+        []string { "exchange", "queue", "key_prefix" },
+    )
+    handlerDuration = promauto.NewHistogramVec(
+        prometheus.HistogramOpts {
+            Name: "peril_handler_duration_seconds",
+            Help: "How long handlers took, including middleware.",
+            Buckets: prometheus.DefBuckets,
+        },
+        []string { "exchange", "queue", "key_prefix" },
+    )
+)
+
+func keyPrefix(key string) string {
+    prefix, _, _ := strings.Cut(key, ".")
+    return prefix
+}
+
+func observeHandled(exchange, queueName, key string, ack AckType, elapsed time.Duration) {
+    prefix := keyPrefix(key)
+    messageOutcomes.WithLabelValues(exchange, queueName, prefix, ackTypeName(ack)).Inc()
+    handlerDuration.WithLabelValues(exchange, queueName, prefix).Observe(elapsed.Seconds())
+}
@@ -0,0 +1,89 @@
+package pubsub
+
+import (
+    "fmt"
+    "log/slog"
+    "runtime/debug"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Handler handles a decoded delivery. The decoded body is bound by the
+// subscription, so a Handler only sees the delivery's metadata.
+type Handler func(message amqp.Delivery) AckType
+
+// Middleware wraps a Handler to run code around every delivery.
+type Middleware func(Handler) Handler
+
+// WithMiddleware wraps the subscription's handler in middlewares. The first
+// middleware is the outermost.
+func WithMiddleware(middlewares ...Middleware) SubscribeOption {
+    return func(options *subscribeOptions) {
+        options.middlewares = append(options.middlewares, middlewares...)
+    }
+}
+
+func chain(handler Handler, middlewares []Middleware) Handler {
+    for i := len(middlewares) - 1; i >= 0; i-- {
+        handler = middlewares[i](handler)
+    }
+    return handler
+}
+
+func ackTypeName(ack AckType) string {
+    switch ack {
+    case AckTypeAck: return "ack"
+    case AckTypeNackRequeue: return "nack_requeue"
+    case AckTypeNackDiscard: return "nack_discard"
+    case AckTypeRetryLater: return "retry_later"
+    default: return fmt.Sprintf("unknown_%v", ack)
+    }
+}
+
+// Recover turns a panicking handler into AckTypeNackDiscard, which sends
+// the message to the dead-letter queue instead of crashing the process.
+func Recover() Middleware {
+    return func(next Handler) Handler {
+        return func(message amqp.Delivery) (ack AckType) {
+            defer func() {
+                if r := recover(); r != nil {
+                    fmt.Printf("Handler panicked on %v: %v\n%s", message.RoutingKey, r, debug.Stack())
+                    ack = AckTypeNackDiscard
+                }
+            }()
+            return next(message)
+        }
+    }
+}
+
+// Logging logs every handled delivery with its routing, redelivery flag,
+// outcome and how long the handler took.
+func Logging(logger *slog.Logger) Middleware {
+    return func(next Handler) Handler {
+        return func(message amqp.Delivery) AckType {
+            start := time.Now()
+            ack := next(message)
+            logger.Info(
+                "handled message",
+                "exchange", message.Exchange,
+                "routing_key", message.RoutingKey,
+                "redelivered", message.Redelivered,
+                "ack", ackTypeName(ack),
+                "duration", time.Since(start),
+            )
+            return ack
+        }
+    }
+}
+
+// Duration calls observe with how long the handler took for each delivery.
+func Duration(observe func(message amqp.Delivery, ack AckType, elapsed time.Duration)) Middleware {
+    return func(next Handler) Handler {
+        return func(message amqp.Delivery) AckType {
+            start := time.Now()
+            ack := next(message)
+            observe(message, ack, time.Since(start))
+            return ack
+        }
+    }
+}
@@ -0,0 +1,55 @@
+package pubsub
+
+import (
+    "context"
+    "github.com/vmihailenco/msgpack/v5"
+)
+
+const CodecMsgPack = "msgpack"
+
+func init() {
+    RegisterCodec(CodecMsgPack, msgpackCodec{})
+}
+
+func MsgPack[T any]() CodecFor[T] { return CodecFor[T] { name: CodecMsgPack } }
+
+// msgpackCodec encodes structs as maps keyed by field name, so any
+// MessagePack library can read them without a schema.
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Encode(val any) ([]byte, error) {
+    return msgpack.Marshal(val)
+}
+
+func (msgpackCodec) Decode(data []byte, out any) error {
+    return msgpack.Unmarshal(data, out)
+}
+
+func SubscribeMsgPack[T any](
+    ctx context.Context,
+    subscriber Subscriber,
+    exchange,
+    queueName,
+    key string,
+    queueType QueueType,
+    handler func(T) AckType,
+    opts ...SubscribeOption,
+) (*Subscription, error) {
+    return subscribe(
+        ctx,
+        subscriber,
+        exchange,
+        queueName,
+        key,
+        queueType,
+        bodyOnly(handler),
+        decodeWith[T](msgpackCodec{}),
+        opts,
+    )
+}
+
+func PublishMsgPack[T any](publisher Publisher, exchange, key string, val T) error {
+    return publish(context.Background(), publisher, exchange, key, val, msgpackCodec{})
+}
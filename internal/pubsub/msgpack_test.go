@@ -0,0 +1,98 @@
+package pubsub_test
+
+import (
+    "bytes"
+    "encoding/gob"
+    "encoding/json"
+    "reflect"
+    "testing"
+    "time"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+    "github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// roundTrip encodes val with encode and decodes it into a new T with decode.
+func roundTrip[T any](t *testing.T, val T, encode func(any) ([]byte, error), decode func([]byte, any) error) T {
+    t.Helper()
+    data, err := encode(val)
+    if err != nil { t.Fatal(err) }
+    var out T
+    if err := decode(data, &out); err != nil { t.Fatal(err) }
+    return out
+}
+
+func gobEncode(val any) ([]byte, error) {
+    var buffer bytes.Buffer
+    err := gob.NewEncoder(&buffer).Encode(val)
+    return buffer.Bytes(), err
+}
+
+func gobDecode(data []byte, out any) error {
+    return gob.NewDecoder(bytes.NewReader(data)).Decode(out)
+}
+
+// utc drops the zone MessagePack decodes timestamps in, since they carry none.
+func utc(val any) any {
+    if gamelog, ok := val.(routing.GameLog); ok {
+        gamelog.CurrentTime = gamelog.CurrentTime.UTC()
+        return gamelog
+    }
+    return val
+}
+
+// checkMsgPack compares a MessagePack round trip of val with JSON and gob
+// ones.
+func checkMsgPack[T any](t *testing.T, val T) {
+    codec, err := pubsub.CodecByName(pubsub.CodecMsgPack)
+    if err != nil { t.Fatal(err) }
+    got := roundTrip(t, val, codec.Encode, codec.Decode)
+    for name, want := range map[string]T {
+        "JSON": roundTrip(t, val, json.Marshal, json.Unmarshal),
+        "gob": roundTrip(t, val, gobEncode, gobDecode),
+    } {
+        if !reflect.DeepEqual(utc(got), utc(want)) {
+            t.Errorf("MessagePack round trip = %+v, want %+v as through %v", got, want, name)
+        }
+    }
+}
+
+func TestMsgPackRoundTrips(t *testing.T) {
+    player := gamelogic.Player {
+        Username: "alice",
+        Units: map[int]gamelogic.Unit {
+            1: { ID: 1, Rank: gamelogic.RankInfantry, Location: "europe", Owner: "alice" },
+            3: { ID: 3, Rank: gamelogic.RankArtillery, Location: "asia", Owner: "alice" },
+        },
+        NextUnitID: 4,
+        Funds: 12,
+    }
+    t.Run("PlayingState", func(t *testing.T) {
+        checkMsgPack(t, routing.PlayingState { IsPaused: true })
+    })
+    t.Run("GameLog", func(t *testing.T) {
+        checkMsgPack(t, routing.GameLog {
+            CurrentTime: time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC),
+            Message: "bob won a war against alice",
+            Username: "alice",
+        })
+    })
+    t.Run("ArmyMove", func(t *testing.T) {
+        checkMsgPack(t, gamelogic.ArmyMove {
+            Player: player,
+            Units: []gamelogic.Unit { player.Units[3] },
+            ToLocation: "asia",
+        })
+    })
+    t.Run("WarResult", func(t *testing.T) {
+        checkMsgPack(t, gamelogic.WarResult {
+            Attacker: "alice",
+            Defender: "bob",
+            Winner: "bob",
+            Loser: "alice",
+            Location: "asia",
+            AttackerLosses: []gamelogic.Unit { player.Units[3] },
+            DefenderLosses: []gamelogic.Unit { { ID: 2, Rank: gamelogic.RankCavalry, Location: "asia", Owner: "bob" } },
+        })
+    })
+}
@@ -0,0 +1,204 @@
+package pubsub
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// How long the outbox waits for each publish to be confirmed, and how long
+// it backs off after a failed one.
+const (
+    outboxPublishTimeout = 5 * time.Second
+    outboxMinDelay = 500 * time.Millisecond
+    outboxMaxDelay = 30 * time.Second
+)
+
+// OutboxMessage is a message waiting in an Outbox to be published.
+type OutboxMessage struct {
+    Exchange string
+    Key string
+    Publishing amqp.Publishing
+}
+
+// NewOutboxMessage encodes val with the codec registered under codecName.
+// The message ID is fixed here, so consumers using Dedupe skip copies
+// published again after a crash.
+func NewOutboxMessage[T any](codecName, exchange, key string, val T) (OutboxMessage, error) {
+    codec, err := codecByName(codecName)
+    if err != nil { return OutboxMessage {}, err }
+    body, err := codec.Encode(val)
+    if err != nil { return OutboxMessage {}, err }
+    return OutboxMessage {
+        Exchange: exchange,
+        Key: key,
+        Publishing: amqp.Publishing {
+            ContentType: codec.ContentType(),
+            MessageId: NewMessageID(),
+            Timestamp: time.Now(),
+            Body: body,
+        },
+    }, nil
+}
+
+// The outbox file holds the latest committed state and the messages not yet
+// confirmed by the broker, oldest first.
+type outboxFile struct {
+    State json.RawMessage `json:"state,omitempty"`
+    Pending []OutboxMessage `json:"pending"`
+}
+
+// Outbox makes a state change and the messages announcing it durable
+// together. Commit writes both to a local file before the change is
+// applied, and Run publishes the messages in order, with confirms, until
+// the broker has taken every one of them. Messages left over when the
+// process stops are published the next time the outbox is run.
+type Outbox struct {
+    path string
+    publisher ConfirmPublisher
+
+    mu sync.Mutex
+    file outboxFile
+    wake chan struct{}
+}
+
+// OpenOutbox loads the outbox stored at path, or starts an empty one if
+// there is no file there yet.
+func OpenOutbox(path string, publisher ConfirmPublisher) (*Outbox, error) {
+    outbox := &Outbox {
+        path: path,
+        publisher: publisher,
+        wake: make(chan struct{}, 1),
+    }
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) { return outbox, nil }
+    if err != nil { return nil, err }
+    if err := json.Unmarshal(data, &outbox.file); err != nil {
+        return nil, fmt.Errorf("outbox %v: %w", path, err)
+    }
+    return outbox, nil
+}
+
+// State decodes the last committed state into out. It reports false if no
+// state has been committed.
+func (o *Outbox) State(out any) (bool, error) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if o.file.State == nil { return false, nil }
+    return true, json.Unmarshal(o.file.State, out)
+}
+
+// Pending returns how many messages are waiting to be published.
+func (o *Outbox) Pending() int {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    return len(o.file.Pending)
+}
+
+// Commit durably records state as the current state and queues messages
+// for publishing. Only apply the state change once Commit has succeeded.
+func (o *Outbox) Commit(state any, messages ...OutboxMessage) error {
+    encoded, err := json.Marshal(state)
+    if err != nil { return err }
+
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    file := outboxFile {
+        State: encoded,
+        Pending: append(append([]OutboxMessage {}, o.file.Pending...), messages...),
+    }
+    if err := o.write(file); err != nil { return err }
+    o.file = file
+
+    select {
+    case o.wake <- struct{}{}:
+    default:
+    }
+    return nil
+}
+
+// write replaces the outbox file, so a crash leaves either the old or the
+// new contents. Must be called with o.mu held.
+func (o *Outbox) write(file outboxFile) error {
+    data, err := json.Marshal(file)
+    if err != nil { return err }
+    temp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path) + ".*.tmp")
+    if err != nil { return err }
+    defer os.Remove(temp.Name())
+    if _, err := temp.Write(data); err != nil {
+        temp.Close()
+        return err
+    }
+    if err := temp.Sync(); err != nil {
+        temp.Close()
+        return err
+    }
+    if err := temp.Close(); err != nil { return err }
+    return os.Rename(temp.Name(), o.path)
+}
+
+func (o *Outbox) next() (OutboxMessage, bool) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if len(o.file.Pending) == 0 { return OutboxMessage {}, false }
+    return o.file.Pending[0], true
+}
+
+// delivered removes the oldest pending message, which the broker has
+// confirmed.
+func (o *Outbox) delivered() error {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    file := outboxFile { State: o.file.State, Pending: o.file.Pending[1:] }
+    if err := o.write(file); err != nil { return err }
+    o.file = file
+    return nil
+}
+
+// Run publishes pending messages until ctx is done.
+func (o *Outbox) Run(ctx context.Context) {
+    delay := outboxMinDelay
+    for {
+        message, ok := o.next()
+        if !ok {
+            select {
+            case <-o.wake: continue
+            case <-ctx.Done(): return
+            }
+        }
+
+        publishing := message.Publishing
+        publishing.Headers = amqp.Table {}
+        for k, v := range message.Publishing.Headers {
+            publishing.Headers[k] = v
+        }
+        publishCtx, cancel := context.WithTimeout(ctx, outboxPublishTimeout)
+        publishCtx, span := startPublishSpan(publishCtx, message.Exchange, message.Key, &publishing)
+        err := o.publisher.PublishConfirmed(publishCtx, message.Exchange, message.Key, publishing)
+        endSpan(span, err)
+        cancel()
+        // An unroutable message is retried like any other failure: messages
+        // worth keeping in an outbox go to durable queues, so their queue is
+        // missing only until the topology is declared again.
+        if err == nil {
+            err = o.delivered()
+        }
+        if err == nil {
+            delay = outboxMinDelay
+            continue
+        }
+
+        fmt.Printf("Failed to publish outbox message to %v, retrying in %v: %v\n", message.Key, delay, err)
+        select {
+        case <-time.After(delay):
+        case <-ctx.Done(): return
+        }
+        delay = min(delay * 2, outboxMaxDelay)
+    }
+}
@@ -0,0 +1,49 @@
+package pubsub
+
+import (
+    "context"
+    "path/filepath"
+    "testing"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestOutboxKeepsUnroutableMessages(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+    path := filepath.Join(t.TempDir(), "test.outbox.json")
+    outbox, err := OpenOutbox(path, connection)
+    must(t, err)
+    message, err := NewOutboxMessage(CodecJSON, "topic", "intents.bob", "spawn")
+    must(t, err)
+    must(t, outbox.Commit("state", message))
+
+    ctx, cancel := context.WithCancel(context.Background())
+    stopped := make(chan struct{})
+    go func() {
+        outbox.Run(ctx)
+        close(stopped)
+    }()
+    // Stop writing the outbox before the test's directory is removed.
+    defer func() {
+        cancel()
+        <-stopped
+    }()
+    // No queue is bound yet, as if the broker had lost the topology.
+    time.Sleep(50 * time.Millisecond)
+    if pending := outbox.Pending(); pending != 1 {
+        t.Fatalf("outbox has %v pending messages while unroutable, want 1", pending)
+    }
+    reopened, err := OpenOutbox(path, connection)
+    must(t, err)
+    if pending := reopened.Pending(); pending != 1 {
+        t.Fatalf("outbox file has %v pending messages while unroutable, want 1", pending)
+    }
+
+    deliveries := consume(t, connection, "topic", "intents", "intents.*")
+    delivery := receive(t, deliveries)
+    if delivery.MessageId != message.Publishing.MessageId || string(delivery.Body) != `"spawn"` {
+        t.Errorf("delivered %v: %s, want %v: %q", delivery.MessageId, delivery.Body, message.Publishing.MessageId, `"spawn"`)
+    }
+}
@@ -0,0 +1,61 @@
+package pubsub
+
+import (
+    "context"
+    "fmt"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// HeaderDecodeError holds the reason an undecodable message was
+// dead-lettered.
+const HeaderDecodeError = "x-decode-error"
+
+// WithDecodeErrorHandler calls onError for each message that could not be
+// decoded, after it has been dead-lettered.
+func WithDecodeErrorHandler(onError func(message amqp.Delivery, err error)) SubscribeOption {
+    return func(options *subscribeOptions) {
+        options.onDecodeError = onError
+    }
+}
+
+// DecodeFailures is the number of undecodable messages the subscription has
+// dead-lettered.
+func (s *Subscription) DecodeFailures() int64 {
+    return s.decodeFailures.Load()
+}
+
+// rejectUndecodable dead-letters a message that could not be decoded. When
+// the subscriber can publish, the message is sent to the dead-letter
+// exchange with the decode error in its headers, otherwise it is nacked to
+// the queue's dead-letter exchange as is.
+func (s *Subscription) rejectUndecodable(message amqp.Delivery, decodeErr error) {
+    s.decodeFailures.Add(1)
+    if s.options.onDecodeError != nil {
+        defer s.options.onDecodeError(message, decodeErr)
+    }
+
+    if publisher, ok := s.subscriber.(ConfirmPublisher); ok {
+        publishing := PublishingFrom(message)
+        publishing.Headers = amqp.Table {}
+        for k, v := range message.Headers {
+            publishing.Headers[k] = v
+        }
+        publishing.Headers[HeaderDecodeError] = decodeErr.Error()
+        publishing.Headers[HeaderOriginalExchange] = message.Exchange
+        publishing.Headers[HeaderOriginalRoutingKey] = message.RoutingKey
+
+        ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+        defer cancel()
+        err := publisher.PublishConfirmed(ctx, DeadLetterExchange, message.RoutingKey, publishing)
+        if err == nil {
+            message.Ack(false)
+            fmt.Println("Message dead-lettered")
+            return
+        }
+        fmt.Printf("Failed to dead-letter message: %v\n", err)
+    }
+
+    message.Nack(false, false)
+    fmt.Println("Message nack discard")
+}
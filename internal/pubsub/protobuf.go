@@ -0,0 +1,72 @@
+package pubsub
+
+import (
+    "context"
+    "fmt"
+)
+
+// ProtoMarshaler and ProtoUnmarshaler are implemented by the message types
+// with a protobuf encoding. The schema lives in internal/routing/peril.proto.
+type ProtoMarshaler interface {
+    MarshalProto() ([]byte, error)
+}
+
+type ProtoUnmarshaler interface {
+    UnmarshalProto([]byte) error
+}
+
+const CodecProtobuf = "protobuf"
+
+func init() {
+    RegisterCodec(CodecProtobuf, protobufCodec{})
+}
+
+// Protobuf is the protobuf codec for a T with a protobuf encoding.
+func Protobuf[T ProtoMarshaler, PT interface { *T; ProtoUnmarshaler }]() CodecFor[T] {
+    return CodecFor[T] { name: CodecProtobuf }
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) Encode(val any) ([]byte, error) {
+    marshaler, ok := val.(ProtoMarshaler)
+    if !ok { return nil, fmt.Errorf("%T has no protobuf encoding", val) }
+    return marshaler.MarshalProto()
+}
+
+func (protobufCodec) Decode(data []byte, out any) error {
+    unmarshaler, ok := out.(ProtoUnmarshaler)
+    if !ok { return fmt.Errorf("%T has no protobuf encoding", out) }
+    return unmarshaler.UnmarshalProto(data)
+}
+
+// SubscribeProto is like SubscribeJSON for a T with a protobuf encoding. PT
+// is only there so that a T without one fails to compile.
+func SubscribeProto[T any, PT interface { *T; ProtoUnmarshaler }](
+    ctx context.Context,
+    subscriber Subscriber,
+    exchange,
+    queueName,
+    key string,
+    queueType QueueType,
+    handler func(T) AckType,
+    opts ...SubscribeOption,
+) (*Subscription, error) {
+    return subscribe(
+        ctx,
+        subscriber,
+        exchange,
+        queueName,
+        key,
+        queueType,
+        bodyOnly(handler),
+        decodeWith[T](protobufCodec{}),
+        opts,
+    )
+}
+
+func PublishProto[T ProtoMarshaler](publisher Publisher, exchange, key string, val T) error {
+    return publish(context.Background(), publisher, exchange, key, val, protobufCodec{})
+}
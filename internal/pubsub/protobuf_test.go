@@ -0,0 +1,56 @@
+package pubsub
+
+import (
+    "context"
+    "testing"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// protoText is a message whose protobuf encoding is its text, as field 1.
+type protoText struct {
+    Text string
+}
+
+func (p protoText) MarshalProto() ([]byte, error) {
+    return append([]byte { 0x0a, byte(len(p.Text)) }, p.Text...), nil
+}
+
+func (p *protoText) UnmarshalProto(b []byte) error {
+    p.Text = string(b[2:])
+    return nil
+}
+
+func TestSubscribeProtoDecompresses(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+
+    received := make(chan string, 2)
+    subscription, err := SubscribeProto(context.Background(), connection, "topic", "texts", "texts.*", DurableQueue, func(p protoText) AckType {
+        received <- p.Text
+        return AckTypeAck
+    })
+    must(t, err)
+    defer subscription.Close()
+
+    must(t, PublishProto(connection, "topic", "texts.plain", protoText { Text: "plain" }))
+    body, err := protoText { Text: "gzipped" }.MarshalProto()
+    must(t, err)
+    compressed, err := compress(body, CompressionGzip)
+    must(t, err)
+    must(t, connection.Publish(context.Background(), "topic", "texts.gzip", amqp.Publishing {
+        ContentType: protobufCodec{}.ContentType(),
+        ContentEncoding: CompressionGzip,
+        Body: compressed,
+    }))
+
+    for _, want := range []string { "plain", "gzipped" } {
+        select {
+        case got := <-received:
+            if got != want { t.Errorf("got %q, want %q", got, want) }
+        case <-time.After(testTimeout):
+            t.Fatalf("timed out waiting for %q", want)
+        }
+    }
+}
@@ -1,61 +1,87 @@
 package pubsub
 
 import (
-    "encoding/json"
-    "encoding/gob"
+    "sync"
+    "sync/atomic"
     "context"
     "fmt"
-    "bytes"
+    "time"
     amqp "github.com/rabbitmq/amqp091-go"
+    "go.opentelemetry.io/otel/attribute"
 )
 
 // Queue types
+type QueueType = int
 const (
-    DurableQueue = iota
-    TransientQueue = iota
+    DurableQueue QueueType = iota
+    TransientQueue QueueType = iota
 )
 
+// NewQueueSpec describes a queue of the given type that dead-letters to
+// DeadLetterExchange. Transient queues belong to the declaring connection.
+func NewQueueSpec(name string, queueType QueueType) QueueSpec {
+    isDurable := queueType == DurableQueue
+    return QueueSpec {
+        Name: name,
+        Durable: isDurable,
+        AutoDelete: !isDurable,
+        Exclusive: !isDurable,
+        DeadLetterExchange: DeadLetterExchange,
+    }
+}
+
 func DeclareAndBind(
-    connection *amqp.Connection,
+    subscriber Subscriber,
     exchange,
     queueName,
     key string,
-    queueType AckType,
-) (*amqp.Channel, amqp.Queue, error) {
-    var queue amqp.Queue
+    queueType QueueType,
+) (amqp.Queue, error) {
+    return declareAndBind(subscriber, exchange, NewQueueSpec(queueName, queueType), key)
+}
 
-    isDurable := queueType == DurableQueue
+func declareAndBind(subscriber Subscriber, exchange string, spec QueueSpec, key string) (amqp.Queue, error) {
+    var queue amqp.Queue
 
-    connectionChannel, err := connection.Channel()
-    if err != nil {
-        return nil, queue, err
+    if err := DeclareDeadLetter(subscriber); err != nil {
+        return queue, err
     }
-    table := amqp.Table { "x-dead-letter-exchange": "peril_dlx" }
-    queue, err = connectionChannel.QueueDeclare(queueName, isDurable, !isDurable, !isDurable, false, table)
+    queue, err := spec.Declare(subscriber)
     if err != nil {
-        return nil, queue, err
+        return queue, err
     }
-    if err := connectionChannel.QueueBind(queueName, key, exchange, false, nil); err != nil {
-        return nil, queue, err
+    if err := subscriber.QueueBind(spec.Name, key, exchange); err != nil {
+        return queue, err
     }
 
-    return connectionChannel, queue, nil
+    return queue, nil
 }
 
 func handleDeliveryMessages[T any](
+    subscription *Subscription,
     deliveryChannel <-chan amqp.Delivery,
-    handler func(T) AckType,
-    decoder func([]byte, *T) error,
+    handler func(context.Context, amqp.Delivery, T) AckType,
+    decoder func(amqp.Delivery, *T) error,
 ) {
     for message := range deliveryChannel {
+        restoreRoute(&message)
+        messagesConsumed.WithLabelValues(message.Exchange, subscription.queueName, keyPrefix(message.RoutingKey)).Inc()
+        ctx, span := startConsumeSpan(subscription.queueName, message)
         var body T
-        if err := decoder(message.Body, &body); err != nil {
-            fmt.Println("Failed to unmarshal message body")
-            message.Nack(false, false) // discard it
-            return
+        if err := decoder(message, &body); err != nil {
+            fmt.Printf("Failed to unmarshal message body: %v\n", err)
+            decodeFailures.WithLabelValues(message.Exchange, subscription.queueName, keyPrefix(message.RoutingKey)).Inc()
+            subscription.rejectUndecodable(message, err)
+            endSpan(span, err)
+            continue
         }
 
-        switch handler(body) {
+        handle := chain(func(message amqp.Delivery) AckType { return handler(ctx, message, body) }, subscription.options.middlewares)
+        start := time.Now()
+        ack := handle(message)
+        observeHandled(message.Exchange, subscription.queueName, message.RoutingKey, ack, time.Since(start))
+        span.SetAttributes(attribute.String("peril.ack", ackTypeName(ack)))
+        switch ack {
         case AckTypeAck:
             message.Ack(false)
             fmt.Println("Message ack")
@@ -65,7 +91,10 @@ func handleDeliveryMessages[T any](
         case AckTypeNackDiscard:
             message.Nack(false, false)
             fmt.Println("Message nack discard")
+        case AckTypeRetryLater:
+            retryLater(message, subscription.queueName, subscription.options.retry)
         }
+        endSpan(span, nil)
     }
 }
 
@@ -74,119 +103,233 @@ const (
     AckTypeAck AckType = iota
     AckTypeNackRequeue AckType = iota
     AckTypeNackDiscard AckType = iota
+    // Requeue after a delay, see WithRetry.
+    AckTypeRetryLater AckType = iota
 )
 
+// Subscription is a consumer started by SubscribeJSON or SubscribeGob. It
+// runs until its context is cancelled or it is closed.
+type Subscription struct {
+    subscriber Subscriber
+    queueName string
+    options subscribeOptions
+    consumer Consumer
+    decodeFailures atomic.Int64
+    cancelOnce sync.Once
+    done chan struct{}
+    err error
+}
+
+func (s *Subscription) cancel() {
+    s.cancelOnce.Do(func() {
+        if err := s.consumer.Cancel(); err != nil {
+            fmt.Printf("Failed to cancel consumer: %v\n", err)
+        }
+    })
+}
+
+// Done is closed once the subscription has stopped, its in-flight message
+// has been handled and acked, and its channel has been closed.
+func (s *Subscription) Done() <-chan struct{} {
+    return s.done
+}
+
+// Close stops the subscription and waits for it to drain.
+func (s *Subscription) Close() error {
+    s.cancel()
+    <-s.done
+    return s.err
+}
+
 func subscribe[T any](
-    connection *amqp.Connection,
+    ctx context.Context,
+    subscriber Subscriber,
     exchange,
     queueName,
     key string,
-    queueType AckType,
-    handler func(T) AckType,
-    decoder func([]byte, *T) error,
-) error {
-    connectionChannel, _, err := DeclareAndBind(connection, exchange, queueName, key, queueType)
-    if err != nil { return err }
-    if err := connectionChannel.Qos(10, 0, false); err != nil { return err }
-    deliveryChannel, err := connectionChannel.Consume(queueName, "", false, false, false, false, nil)
-    if err != nil {
-        connectionChannel.Close()
-        return err
+    queueType QueueType,
+    handler func(context.Context, amqp.Delivery, T) AckType,
+    decoder func(amqp.Delivery, *T) error,
+    opts []SubscribeOption,
+) (*Subscription, error) {
+    options := newSubscribeOptions(opts)
+    spec := NewQueueSpec(queueName, queueType)
+    spec.SingleActiveConsumer = options.singleActiveConsumer
+    if _, err := declareAndBind(subscriber, exchange, spec, key); err != nil { return nil, err }
+    if options.retry != nil {
+        if err := declareRetryQueues(subscriber, options.retry); err != nil { return nil, err }
     }
-    go handleDeliveryMessages(deliveryChannel, handler, decoder)
-    return nil
+    consumer, err := subscriber.Consume(queueName, options.prefetch())
+    if err != nil { return nil, err }
+
+    subscription := &Subscription {
+        subscriber: subscriber,
+        queueName: queueName,
+        options: options,
+        consumer: consumer,
+        done: make(chan struct{}),
+    }
+    go func() {
+        runWorkers(consumer.Deliveries(), options, func(deliveryChannel <-chan amqp.Delivery) {
+            handleDeliveryMessages(subscription, deliveryChannel, handler, decoder)
+        })
+        subscription.err = consumer.Close()
+        close(subscription.done)
+    }()
+    go func() {
+        select {
+        case <-ctx.Done(): subscription.cancel()
+        case <-subscription.done:
+        }
+    }()
+    return subscription, nil
+}
+
+// bodyOnly adapts a handler that only needs the decoded body.
+func bodyOnly[T any](handler func(T) AckType) func(context.Context, amqp.Delivery, T) AckType {
+    return func(_ context.Context, _ amqp.Delivery, body T) AckType { return handler(body) }
 }
 
 func publish[T any](
-    publishChannel *amqp.Channel,
+    ctx context.Context,
+    publisher Publisher,
     exchange string,
     key string,
     val T,
-    contentType string,
-    encoder func(T) ([]byte, error),
+    codec Codec,
 ) error {
-    bytes, err := encoder(val)
+    bytes, err := codec.Encode(val)
     if err != nil { return err }
 
-    ctx := context.Background()
     publishSettings := amqp.Publishing {
-        ContentType: contentType,
+        ContentType: codec.ContentType(),
+        MessageId: NewMessageID(),
         Body: bytes,
     }
-    if err := publishChannel.PublishWithContext(ctx, exchange, key, false, false, publishSettings); err != nil {
-        return err
-    }
+    ctx, span := startPublishSpan(ctx, exchange, key, &publishSettings)
+    err = publisher.Publish(ctx, exchange, key, publishSettings)
+    endSpan(span, err)
+    if err != nil { return err }
+    messagesPublished.WithLabelValues(exchange, keyPrefix(key)).Inc()
 
     return nil
 }
 
-func SubscribeJSON[T any](
-    connection *amqp.Connection,
+// Decode decodes a delivery with the codec registered for its content type.
+// Compressed bodies are decompressed according to the content encoding.
+func Decode(message amqp.Delivery, out any) error {
+    codec, err := codecForContentType(message.ContentType)
+    if err != nil { return err }
+    body, err := decompress(message.Body, message.ContentEncoding)
+    if err != nil { return err }
+    return codec.Decode(body, out)
+}
+
+// decodeWith decodes every delivery with codec, whatever its content type.
+func decodeWith[T any](codec Codec) func(amqp.Delivery, *T) error {
+    return func(message amqp.Delivery, out *T) error {
+        body, err := decompress(message.Body, message.ContentEncoding)
+        if err != nil { return err }
+        return codec.Decode(body, out)
+    }
+}
+
+// decodeByContentType decodes each delivery with the codec registered for
+// its content type.
+func decodeByContentType[T any](message amqp.Delivery, out *T) error {
+    return Decode(message, out)
+}
+
+// Subscribe is like SubscribeJSON and SubscribeGob, but accepts any
+// registered codec, choosing one per delivery from its content type. This
+// lets publishers of a queue switch codecs one at a time.
+func Subscribe[T any](
+    ctx context.Context,
+    subscriber Subscriber,
     exchange,
     queueName,
     key string,
-    queueType AckType,
+    queueType QueueType,
     handler func(T) AckType,
-) error {
+    opts ...SubscribeOption,
+) (*Subscription, error) {
     return subscribe(
-        connection,
+        ctx,
+        subscriber,
         exchange,
         queueName,
         key,
         queueType,
-        handler,
-        func (data []byte, out *T) error {
-            return json.Unmarshal(data, out)
-        },
+        bodyOnly(handler),
+        decodeByContentType[T],
+        opts,
     )
 }
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
-    return publish(
-        ch,
+// Publish encodes val with the codec registered under codecName.
+func Publish[T any](publisher Publisher, codecName, exchange, key string, val T) error {
+    return PublishContext(context.Background(), publisher, codecName, exchange, key, val)
+}
+
+// PublishContext is like Publish, but continues the trace in ctx. Handlers
+// pass their Delivery's Context so that messages they publish are traced as
+// part of the message they are handling.
+func PublishContext[T any](ctx context.Context, publisher Publisher, codecName, exchange, key string, val T) error {
+    codec, err := codecByName(codecName)
+    if err != nil { return err }
+    return publish(ctx, publisher, exchange, key, val, codec)
+}
+
+func SubscribeJSON[T any](
+    ctx context.Context,
+    subscriber Subscriber,
+    exchange,
+    queueName,
+    key string,
+    queueType QueueType,
+    handler func(T) AckType,
+    opts ...SubscribeOption,
+) (*Subscription, error) {
+    return subscribe(
+        ctx,
+        subscriber,
         exchange,
+        queueName,
         key,
-        val,
-        "application/json",
-        func(val T) ([]byte, error) { return json.Marshal(val) },
+        queueType,
+        bodyOnly(handler),
+        decodeWith[T](jsonCodec{}),
+        opts,
     )
 }
 
+func PublishJSON[T any](publisher Publisher, exchange, key string, val T) error {
+    return publish(context.Background(), publisher, exchange, key, val, jsonCodec{})
+}
+
 func SubscribeGob[T any](
-    connection *amqp.Connection,
+    ctx context.Context,
+    subscriber Subscriber,
     exchange,
     queueName,
     key string,
-    queueType AckType,
+    queueType QueueType,
     handler func(T) AckType,
-) error {
+    opts ...SubscribeOption,
+) (*Subscription, error) {
     return subscribe(
-        connection,
+        ctx,
+        subscriber,
         exchange,
         queueName,
         key,
         queueType,
-        handler,
-        func (data []byte, out *T) error {
-            buffer := bytes.NewBuffer(data)
-            decoder := gob.NewDecoder(buffer)
-            return decoder.Decode(out)
-        },
+        bodyOnly(handler),
+        decodeWith[T](gobCodec{}),
+        opts,
     )
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
-    return publish(
-        ch,
-        exchange,
-        key,
-        val,
-        "application/gob",
-        func(val T) ([]byte, error) {
-            var buffer bytes.Buffer
-            encoder := gob.NewEncoder(&buffer)
-            if err := encoder.Encode(&val); err != nil { return nil, err }
-            return buffer.Bytes(), nil
-        },
-    )
+func PublishGob[T any](publisher Publisher, exchange, key string, val T) error {
+    return publish(context.Background(), publisher, exchange, key, val, gobCodec{})
 }
@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+    "context"
+    "fmt"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// A message retried with AckTypeRetryLater is published to the retry
+// exchange for its delay, with the name of the queue it came from as its
+// routing key. The exchange fans out to a retry queue that holds it for the
+// delay and then dead-letters it through the default exchange, which routes
+// it by that key straight back to the queue it came from.
+const retryExchangePrefix = "peril_retry"
+
+// Headers carried by retried messages. The original exchange and routing key
+// are restored on delivery, so handlers never see the retry queue's routing.
+const (
+    HeaderRetryAttempt = "x-retry-attempt"
+    HeaderOriginalExchange = "x-original-exchange"
+    HeaderOriginalRoutingKey = "x-original-routing-key"
+)
+
+var defaultRetryDelays = []time.Duration {
+    500 * time.Millisecond,
+    2 * time.Second,
+    10 * time.Second,
+}
+
+type retryPolicy struct {
+    publisher ConfirmPublisher
+    maxAttempts int
+    delays []time.Duration
+}
+
+// WithRetry makes AckTypeRetryLater publish the message through publisher to
+// a retry queue, to come back after the attempt's delay. Attempt n waits
+// delays[n-1], or the last delay once they run out. After maxAttempts
+// retries the message is dead-lettered instead. Without WithRetry,
+// AckTypeRetryLater requeues immediately like AckTypeNackRequeue.
+func WithRetry(publisher ConfirmPublisher, maxAttempts int, delays ...time.Duration) SubscribeOption {
+    if len(delays) == 0 { delays = defaultRetryDelays }
+    return func(options *subscribeOptions) {
+        options.retry = &retryPolicy {
+            publisher: publisher,
+            maxAttempts: maxAttempts,
+            delays: delays,
+        }
+    }
+}
+
+func (policy *retryPolicy) delay(attempt int) time.Duration {
+    return policy.delays[min(attempt, len(policy.delays)) - 1]
+}
+
+func retryExchange(delay time.Duration) string {
+    return fmt.Sprintf("%v.%v", retryExchangePrefix, delay.Milliseconds())
+}
+
+// declareRetryQueues declares an exchange and queue for each retry delay.
+func declareRetryQueues(subscriber Subscriber, policy *retryPolicy) error {
+    for _, delay := range policy.delays {
+        name := retryExchange(delay)
+        if err := subscriber.ExchangeDeclare(name, amqp.ExchangeFanout, true); err != nil {
+            return err
+        }
+        args := amqp.Table {
+            "x-message-ttl": delay.Milliseconds(),
+            "x-dead-letter-exchange": "",
+        }
+        if _, err := subscriber.QueueDeclare(name, true, false, false, args); err != nil {
+            return err
+        }
+        if err := subscriber.QueueBind(name, "", name); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func retryAttempt(headers amqp.Table) int {
+    attempt, _ := tableInt(headers, HeaderRetryAttempt)
+    return int(attempt)
+}
+
+// restoreRoute puts back the exchange and routing key a retried message was
+// originally published with.
+func restoreRoute(message *amqp.Delivery) {
+    if exchange, ok := message.Headers[HeaderOriginalExchange].(string); ok {
+        message.Exchange = exchange
+    }
+    if key, ok := message.Headers[HeaderOriginalRoutingKey].(string); ok {
+        message.RoutingKey = key
+    }
+}
+
+// PublishingFrom copies a delivery's body and properties into a publishing,
+// for sending it on again.
+func PublishingFrom(message amqp.Delivery) amqp.Publishing {
+    return amqp.Publishing {
+        Headers: message.Headers,
+        ContentType: message.ContentType,
+        ContentEncoding: message.ContentEncoding,
+        DeliveryMode: message.DeliveryMode,
+        Priority: message.Priority,
+        CorrelationId: message.CorrelationId,
+        ReplyTo: message.ReplyTo,
+        MessageId: message.MessageId,
+        Timestamp: message.Timestamp,
+        Type: message.Type,
+        UserId: message.UserId,
+        AppId: message.AppId,
+        Body: message.Body,
+    }
+}
+
+func retryLater(message amqp.Delivery, queueName string, policy *retryPolicy) {
+    if policy == nil {
+        message.Nack(false, true)
+        fmt.Println("Message nack requeue")
+        return
+    }
+
+    attempt := retryAttempt(message.Headers) + 1
+    if attempt > policy.maxAttempts {
+        message.Nack(false, false)
+        fmt.Println("Message retries exhausted, nack discard")
+        return
+    }
+
+    publishing := PublishingFrom(message)
+    publishing.Headers = amqp.Table {}
+    for k, v := range message.Headers {
+        publishing.Headers[k] = v
+    }
+    publishing.Headers[HeaderRetryAttempt] = int64(attempt)
+    publishing.Headers[HeaderOriginalExchange] = message.Exchange
+    publishing.Headers[HeaderOriginalRoutingKey] = message.RoutingKey
+
+    delay := policy.delay(attempt)
+    ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+    if err := policy.publisher.PublishConfirmed(ctx, retryExchange(delay), queueName, publishing); err != nil {
+        fmt.Printf("Failed to schedule retry: %v\n", err)
+        message.Nack(false, true)
+        fmt.Println("Message nack requeue")
+        return
+    }
+    message.Ack(false)
+    fmt.Printf("Message retry %v in %v\n", attempt, delay)
+}
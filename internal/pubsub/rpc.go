@@ -0,0 +1,201 @@
+package pubsub
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strconv"
+    "sync"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// A reply carrying this header failed on the server, with the header's value
+// as the error message.
+const HeaderRPCError = "x-rpc-error"
+
+// Prefetch of an RPC client's reply queue.
+const rpcReplyPrefetch = 100
+
+// RemoteError is returned by Call when the server's handler failed.
+type RemoteError struct {
+    Message string
+}
+
+func (e *RemoteError) Error() string {
+    return fmt.Sprintf("remote error: %v", e.Message)
+}
+
+// RPCClient sends requests with Call and routes each reply back to its
+// caller by correlation ID. Replies arrive on a queue exclusive to the
+// client, named by the client so it can be declared again after a reconnect.
+type RPCClient struct {
+    subscriber Subscriber
+    publisher Publisher
+    codec Codec
+    replyQueue string
+    consumer Consumer
+
+    mu sync.Mutex
+    pending map[string]chan amqp.Delivery
+    done chan struct{}
+}
+
+// NewRPCClient declares a reply queue and starts consuming it. Requests are
+// encoded with the codec registered under codecName.
+func NewRPCClient(subscriber Subscriber, publisher Publisher, codecName string) (*RPCClient, error) {
+    codec, err := codecByName(codecName)
+    if err != nil { return nil, err }
+
+    replyQueue := "rpc.reply." + NewMessageID()
+    if _, err := subscriber.QueueDeclare(replyQueue, false, true, true, nil); err != nil {
+        return nil, err
+    }
+    consumer, err := subscriber.Consume(replyQueue, rpcReplyPrefetch)
+    if err != nil { return nil, err }
+
+    client := &RPCClient {
+        subscriber: subscriber,
+        publisher: publisher,
+        codec: codec,
+        replyQueue: replyQueue,
+        consumer: consumer,
+        pending: map[string]chan amqp.Delivery {},
+        done: make(chan struct{}),
+    }
+    go client.receive()
+    return client, nil
+}
+
+func (c *RPCClient) receive() {
+    defer close(c.done)
+    for reply := range c.consumer.Deliveries() {
+        reply.Ack(false)
+        c.mu.Lock()
+        waiting, ok := c.pending[reply.CorrelationId]
+        delete(c.pending, reply.CorrelationId)
+        c.mu.Unlock()
+        if !ok {
+            fmt.Printf("Dropped reply %v nobody is waiting for\n", reply.CorrelationId)
+            continue
+        }
+        waiting <- reply
+    }
+}
+
+// Close stops the client and deletes its reply queue. Calls still waiting
+// for a reply wait until their context is done.
+func (c *RPCClient) Close() error {
+    err := c.consumer.Close()
+    <-c.done
+    if deleteErr := c.subscriber.QueueDelete(c.replyQueue); err == nil { err = deleteErr }
+    return err
+}
+
+// Call sends req to exchange with routing key key and waits for the reply,
+// until ctx is done. A request still queued when ctx's deadline passes
+// expires instead of being served.
+func Call[Req, Resp any](ctx context.Context, client *RPCClient, exchange, key string, req Req) (Resp, error) {
+    var resp Resp
+    body, err := client.codec.Encode(req)
+    if err != nil { return resp, err }
+
+    correlationID := NewMessageID()
+    publishing := amqp.Publishing {
+        ContentType: client.codec.ContentType(),
+        CorrelationId: correlationID,
+        ReplyTo: client.replyQueue,
+        Body: body,
+    }
+    if deadline, ok := ctx.Deadline(); ok {
+        publishing.Expiration = strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 0), 10)
+    }
+
+    waiting := make(chan amqp.Delivery, 1)
+    client.mu.Lock()
+    client.pending[correlationID] = waiting
+    client.mu.Unlock()
+    forget := func() {
+        client.mu.Lock()
+        delete(client.pending, correlationID)
+        client.mu.Unlock()
+    }
+
+    ctx, span := startPublishSpan(ctx, exchange, key, &publishing)
+    err = client.publisher.Publish(ctx, exchange, key, publishing)
+    endSpan(span, err)
+    if err != nil {
+        forget()
+        return resp, err
+    }
+
+    select {
+    case reply := <-waiting:
+        if message, ok := reply.Headers[HeaderRPCError].(string); ok {
+            return resp, &RemoteError { Message: message }
+        }
+        err := Decode(reply, &resp)
+        return resp, err
+    case <-ctx.Done():
+        forget()
+        return resp, ctx.Err()
+    }
+}
+
+// Serve handles requests sent with Call to queueName, publishing each
+// handler's response, or its error, back to the caller through publisher.
+// Responses use the codec of their request.
+func Serve[Req, Resp any](
+    ctx context.Context,
+    subscriber Subscriber,
+    publisher Publisher,
+    exchange,
+    queueName,
+    key string,
+    queueType QueueType,
+    handler func(context.Context, Req) (Resp, error),
+    opts ...SubscribeOption,
+) (*Subscription, error) {
+    serve := func(ctx context.Context, message amqp.Delivery, req Req) AckType {
+        if message.ReplyTo == "" {
+            fmt.Printf("Request %v has no reply-to queue\n", message.RoutingKey)
+            return AckTypeNackDiscard
+        }
+        reply := amqp.Publishing {
+            ContentType: message.ContentType,
+            CorrelationId: message.CorrelationId,
+        }
+        resp, err := handler(ctx, req)
+        var codec Codec
+        if err == nil { codec, err = codecForContentType(message.ContentType) }
+        if err == nil { reply.Body, err = codec.Encode(resp) }
+        if err != nil {
+            reply.Body = nil
+            reply.Headers = amqp.Table { HeaderRPCError: err.Error() }
+        }
+
+        ctx, span := startPublishSpan(ctx, "", message.ReplyTo, &reply)
+        err = publisher.Publish(ctx, "", message.ReplyTo, reply)
+        endSpan(span, err)
+        if errors.Is(err, ErrUnroutable) {
+            // The caller has gone away along with its reply queue.
+            return AckTypeAck
+        }
+        if err != nil {
+            fmt.Printf("Failed to reply to %v: %v\n", message.ReplyTo, err)
+            return AckTypeNackRequeue
+        }
+        return AckTypeAck
+    }
+    return subscribe(
+        ctx,
+        subscriber,
+        exchange,
+        queueName,
+        key,
+        queueType,
+        serve,
+        decodeByContentType[Req],
+        opts,
+    )
+}
@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+    "context"
+    "errors"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// startRPC declares the "rpc" exchange on a fresh broker and returns a
+// connection to it with a client whose requests are confirmed.
+func startRPC(t *testing.T) (*MemoryConnection, *RPCClient) {
+    t.Helper()
+    connection := NewMemoryBroker().Connect()
+    t.Cleanup(func() { connection.Close() })
+    must(t, connection.ExchangeDeclare("rpc", amqp.ExchangeDirect, true))
+    client, err := NewRPCClient(connection, WithConfirms(connection, testTimeout), CodecJSON)
+    must(t, err)
+    t.Cleanup(func() { client.Close() })
+    return connection, client
+}
+
+func serveRPC(t *testing.T, connection *MemoryConnection, handler func(context.Context, int) (int, error), opts ...SubscribeOption) {
+    t.Helper()
+    subscription, err := Serve(context.Background(), connection, connection, "rpc", "rpc.double", "double", DurableQueue, handler, opts...)
+    must(t, err)
+    t.Cleanup(func() { subscription.Close() })
+}
+
+func TestCallCorrelatesConcurrentReplies(t *testing.T) {
+    connection, client := startRPC(t)
+    // Later requests are answered sooner, so replies come back out of order.
+    serveRPC(t, connection, func(_ context.Context, n int) (int, error) {
+        time.Sleep(time.Duration(20 - n) * time.Millisecond)
+        return n * 2, nil
+    }, WithWorkers(20))
+
+    ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+    defer cancel()
+    var wg sync.WaitGroup
+    for n := 0; n < 20; n++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            got, err := Call[int, int](ctx, client, "rpc", "double", n)
+            if err != nil || got != n * 2 { t.Errorf("Call(%v) = %v, %v, want %v", n, got, err, n * 2) }
+        }()
+    }
+    wg.Wait()
+}
+
+func TestCallReturnsRemoteError(t *testing.T) {
+    connection, client := startRPC(t)
+    serveRPC(t, connection, func(_ context.Context, n int) (int, error) {
+        return 0, errors.New("no such player")
+    })
+
+    ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+    defer cancel()
+    _, err := Call[int, int](ctx, client, "rpc", "double", 1)
+    var remote *RemoteError
+    if !errors.As(err, &remote) || remote.Message != "no such player" {
+        t.Fatalf("Call error = %v, want a remote error %q", err, "no such player")
+    }
+
+    _, err = Call[int, int](ctx, client, "rpc", "nobody", 1)
+    if !errors.Is(err, ErrUnroutable) { t.Errorf("Call to an unbound key error = %v, want %v", err, ErrUnroutable) }
+}
+
+func TestCallTimeoutExpiresRequest(t *testing.T) {
+    connection, client := startRPC(t)
+    _, err := DeclareAndBind(connection, "rpc", "rpc.double", "double", DurableQueue)
+    must(t, err)
+
+    // Nobody serves the queue yet, so the request waits there until the
+    // caller's deadline, and then expires.
+    ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+    _, err = Call[int, int](ctx, client, "rpc", "double", 1)
+    cancel()
+    if !errors.Is(err, context.DeadlineExceeded) { t.Fatalf("Call error = %v, want %v", err, context.DeadlineExceeded) }
+    time.Sleep(50 * time.Millisecond)
+
+    var handled atomic.Int32
+    serveRPC(t, connection, func(_ context.Context, n int) (int, error) {
+        handled.Add(1)
+        return n * 2, nil
+    })
+    ctx, cancel = context.WithTimeout(context.Background(), testTimeout)
+    defer cancel()
+    got, err := Call[int, int](ctx, client, "rpc", "double", 2)
+    if err != nil || got != 4 { t.Fatalf("Call(2) = %v, %v, want 4", got, err) }
+    if n := handled.Load(); n != 1 { t.Errorf("served %v requests, want only the one that hadn't expired", n) }
+}
+
+func TestCallSetsExpirationFromDeadline(t *testing.T) {
+    connection, client := startRPC(t)
+    requests := consume(t, connection, "rpc", "rpc.double", "double")
+
+    ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+    defer cancel()
+    go Call[int, int](ctx, client, "rpc", "double", 1)
+    request := receive(t, requests)
+    if expiration, err := strconv.Atoi(request.Expiration); err != nil || expiration <= 0 || expiration > int(testTimeout.Milliseconds()) {
+        t.Errorf("request expiration = %q, want at most the %v left until the deadline", request.Expiration, testTimeout)
+    }
+    if request.ReplyTo != client.replyQueue || request.CorrelationId == "" {
+        t.Errorf("request has reply-to %q and correlation ID %q, want %q and an ID", request.ReplyTo, request.CorrelationId, client.replyQueue)
+    }
+
+    go Call[int, int](context.Background(), client, "rpc", "double", 1)
+    if request := receive(t, requests); request.Expiration != "" {
+        t.Errorf("request without a deadline has expiration %q, want none", request.Expiration)
+    }
+}
+
+func TestCallIgnoresReplyAfterGivingUp(t *testing.T) {
+    connection, client := startRPC(t)
+    release := make(chan struct{})
+    serveRPC(t, connection, func(_ context.Context, n int) (int, error) {
+        if n == 1 { <-release }
+        return n * 2, nil
+    })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+    _, err := Call[int, int](ctx, client, "rpc", "double", 1)
+    cancel()
+    if !errors.Is(err, context.DeadlineExceeded) { t.Fatalf("Call error = %v, want %v", err, context.DeadlineExceeded) }
+    // The server answers the abandoned call before the next one.
+    close(release)
+
+    ctx, cancel = context.WithTimeout(context.Background(), testTimeout)
+    defer cancel()
+    got, err := Call[int, int](ctx, client, "rpc", "double", 2)
+    if err != nil || got != 4 { t.Fatalf("Call(2) after a late reply = %v, %v, want 4", got, err) }
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    if len(client.pending) != 0 { t.Errorf("client still waits for %v replies, want none", len(client.pending)) }
+}
+
+func TestCloseDeletesReplyQueue(t *testing.T) {
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    client, err := NewRPCClient(connection, connection, CodecJSON)
+    must(t, err)
+    must(t, client.Close())
+    err = connection.PublishConfirmed(context.Background(), "", client.replyQueue, amqp.Publishing {})
+    if !errors.Is(err, ErrUnroutable) { t.Errorf("publishing to a closed client's reply queue = %v, want ErrUnroutable", err) }
+}
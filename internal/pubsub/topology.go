@@ -0,0 +1,173 @@
+package pubsub
+
+import (
+    "encoding/json"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Topology describes exchanges, queues and the bindings between them.
+// Applying it declares everything it describes, which changes nothing if
+// it already exists with the same settings.
+type Topology struct {
+    Exchanges []ExchangeSpec
+    Queues []QueueSpec
+    Bindings []BindingSpec
+}
+
+type ExchangeSpec struct {
+    Name string
+    Kind string
+    Durable bool
+}
+
+// QueueSpec describes a queue. Zero MessageTTL and MaxLength mean no limit,
+// and an empty DeadLetterExchange means messages are dropped instead of
+// dead-lettered. A SingleActiveConsumer queue delivers to one consumer at a
+// time, failing over to the next when it goes away.
+type QueueSpec struct {
+    Name string
+    Durable bool
+    AutoDelete bool
+    Exclusive bool
+    MessageTTL time.Duration
+    MaxLength int
+    DeadLetterExchange string
+    SingleActiveConsumer bool
+}
+
+type BindingSpec struct {
+    Queue string
+    Exchange string
+    Key string
+}
+
+// Args returns the queue's x- arguments.
+func (spec QueueSpec) Args() amqp.Table {
+    args := amqp.Table {}
+    if spec.MessageTTL > 0 { args["x-message-ttl"] = spec.MessageTTL.Milliseconds() }
+    if spec.MaxLength > 0 { args["x-max-length"] = int64(spec.MaxLength) }
+    if spec.DeadLetterExchange != "" { args["x-dead-letter-exchange"] = spec.DeadLetterExchange }
+    if spec.SingleActiveConsumer { args["x-single-active-consumer"] = true }
+    return args
+}
+
+// Declare declares the queue.
+func (spec QueueSpec) Declare(subscriber Subscriber) (amqp.Queue, error) {
+    return subscriber.QueueDeclare(spec.Name, spec.Durable, spec.AutoDelete, spec.Exclusive, spec.Args())
+}
+
+// Apply declares the topology's exchanges, then its queues, then its
+// bindings.
+func (t Topology) Apply(subscriber Subscriber) error {
+    for _, exchange := range t.Exchanges {
+        if err := subscriber.ExchangeDeclare(exchange.Name, exchange.Kind, exchange.Durable); err != nil {
+            return err
+        }
+    }
+    for _, queue := range t.Queues {
+        if _, err := queue.Declare(subscriber); err != nil { return err }
+    }
+    for _, binding := range t.Bindings {
+        if err := subscriber.QueueBind(binding.Queue, binding.Key, binding.Exchange); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Merge returns a topology with the contents of t followed by others.
+func (t Topology) Merge(others ...Topology) Topology {
+    merged := Topology {
+        Exchanges: append([]ExchangeSpec {}, t.Exchanges...),
+        Queues: append([]QueueSpec {}, t.Queues...),
+        Bindings: append([]BindingSpec {}, t.Bindings...),
+    }
+    for _, other := range others {
+        merged.Exchanges = append(merged.Exchanges, other.Exchanges...)
+        merged.Queues = append(merged.Queues, other.Queues...)
+        merged.Bindings = append(merged.Bindings, other.Bindings...)
+    }
+    return merged
+}
+
+// The subset of the RabbitMQ definitions format that a Topology maps onto.
+type definitions struct {
+    Exchanges []exchangeDefinition `json:"exchanges"`
+    Queues []queueDefinition `json:"queues"`
+    Bindings []bindingDefinition `json:"bindings"`
+}
+
+type exchangeDefinition struct {
+    Name string `json:"name"`
+    Vhost string `json:"vhost"`
+    Type string `json:"type"`
+    Durable bool `json:"durable"`
+    AutoDelete bool `json:"auto_delete"`
+    Internal bool `json:"internal"`
+    Arguments amqp.Table `json:"arguments"`
+}
+
+type queueDefinition struct {
+    Name string `json:"name"`
+    Vhost string `json:"vhost"`
+    Durable bool `json:"durable"`
+    AutoDelete bool `json:"auto_delete"`
+    Arguments amqp.Table `json:"arguments"`
+}
+
+type bindingDefinition struct {
+    Source string `json:"source"`
+    Vhost string `json:"vhost"`
+    Destination string `json:"destination"`
+    DestinationType string `json:"destination_type"`
+    RoutingKey string `json:"routing_key"`
+    Arguments amqp.Table `json:"arguments"`
+}
+
+// Definitions renders the topology as RabbitMQ definitions JSON, for
+// importing into vhost with the management UI or rabbitmqctl
+// import_definitions. Exclusive queues belong to a connection and can't be
+// imported, so they and their bindings are left out.
+func (t Topology) Definitions(vhost string) ([]byte, error) {
+    defs := definitions {
+        Exchanges: []exchangeDefinition {},
+        Queues: []queueDefinition {},
+        Bindings: []bindingDefinition {},
+    }
+    for _, exchange := range t.Exchanges {
+        defs.Exchanges = append(defs.Exchanges, exchangeDefinition {
+            Name: exchange.Name,
+            Vhost: vhost,
+            Type: exchange.Kind,
+            Durable: exchange.Durable,
+            Arguments: amqp.Table {},
+        })
+    }
+    exclusive := map[string]bool {}
+    for _, queue := range t.Queues {
+        if queue.Exclusive {
+            exclusive[queue.Name] = true
+            continue
+        }
+        defs.Queues = append(defs.Queues, queueDefinition {
+            Name: queue.Name,
+            Vhost: vhost,
+            Durable: queue.Durable,
+            AutoDelete: queue.AutoDelete,
+            Arguments: queue.Args(),
+        })
+    }
+    for _, binding := range t.Bindings {
+        if exclusive[binding.Queue] { continue }
+        defs.Bindings = append(defs.Bindings, bindingDefinition {
+            Source: binding.Exchange,
+            Vhost: vhost,
+            Destination: binding.Queue,
+            DestinationType: "queue",
+            RoutingKey: binding.Key,
+            Arguments: amqp.Table {},
+        })
+    }
+    return json.MarshalIndent(defs, "", "  ")
+}
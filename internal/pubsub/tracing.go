@@ -0,0 +1,81 @@
+package pubsub
+
+import (
+    "context"
+    "fmt"
+    amqp "github.com/rabbitmq/amqp091-go"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+    "go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+
+// headerCarrier lets the global propagator read and write trace context in
+// AMQP headers.
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+    value, _ := c[key].(string)
+    return value
+}
+
+func (c headerCarrier) Set(key, value string) {
+    c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+    keys := make([]string, 0, len(c))
+    for key := range c {
+        keys = append(keys, key)
+    }
+    return keys
+}
+
+// startPublishSpan starts a producer span for a message and injects its
+// context into the publishing's headers.
+func startPublishSpan(ctx context.Context, exchange, key string, publishing *amqp.Publishing) (context.Context, trace.Span) {
+    ctx, span := otel.Tracer(tracerName).Start(
+        ctx,
+        fmt.Sprintf("publish %v", exchange),
+        trace.WithSpanKind(trace.SpanKindProducer),
+        trace.WithAttributes(
+            semconv.MessagingSystemRabbitmq,
+            semconv.MessagingOperationTypePublish,
+            semconv.MessagingDestinationName(exchange),
+            semconv.MessagingRabbitmqDestinationRoutingKey(key),
+        ),
+    )
+    if publishing.Headers == nil { publishing.Headers = amqp.Table {} }
+    otel.GetTextMapPropagator().Inject(ctx, headerCarrier(publishing.Headers))
+    return ctx, span
+}
+
+// startConsumeSpan starts a consumer span for a delivery, continuing the
+// trace it was published in.
+func startConsumeSpan(queueName string, message amqp.Delivery) (context.Context, trace.Span) {
+    ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(message.Headers))
+    return otel.Tracer(tracerName).Start(
+        ctx,
+        fmt.Sprintf("process %v", queueName),
+        trace.WithSpanKind(trace.SpanKindConsumer),
+        trace.WithAttributes(
+            semconv.MessagingSystemRabbitmq,
+            semconv.MessagingOperationTypeDeliver,
+            semconv.MessagingDestinationName(message.Exchange),
+            semconv.MessagingRabbitmqDestinationRoutingKey(message.RoutingKey),
+            semconv.MessagingMessageID(message.MessageId),
+            attribute.Bool("messaging.rabbitmq.redelivered", message.Redelivered),
+        ),
+    )
+}
+
+func endSpan(span trace.Span, err error) {
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
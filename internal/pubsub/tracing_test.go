@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+    "context"
+    "testing"
+    "time"
+    amqp "github.com/rabbitmq/amqp091-go"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/propagation"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans sends the test's spans to an in-memory exporter, putting the
+// global tracer provider and propagator back afterwards.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+    exporter := tracetest.NewInMemoryExporter()
+    provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+    previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+    otel.SetTracerProvider(provider)
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+    t.Cleanup(func() {
+        otel.SetTracerProvider(previousProvider)
+        otel.SetTextMapPropagator(previousPropagator)
+        provider.Shutdown(context.Background())
+    })
+    return exporter
+}
+
+func TestTraceFollowsPublishHandlePublish(t *testing.T) {
+    exporter := recordSpans(t)
+    connection := NewMemoryBroker().Connect()
+    defer connection.Close()
+    must(t, connection.ExchangeDeclare("topic", amqp.ExchangeTopic, true))
+
+    moves, err := SubscribeDelivery(context.Background(), connection, "topic", "moves", "moves.*", DurableQueue, func(delivery Delivery[string]) AckType {
+        if err := PublishContext(delivery.Context, connection, CodecJSON, "topic", "logs.bob", "logged " + delivery.Body); err != nil {
+            t.Errorf("publishing the log: %v", err)
+        }
+        return AckTypeAck
+    })
+    must(t, err)
+    logged := make(chan trace.SpanContext, 1)
+    logs, err := SubscribeDelivery(context.Background(), connection, "topic", "logs", "logs.*", DurableQueue, func(delivery Delivery[string]) AckType {
+        logged <- trace.SpanContextFromContext(delivery.Context)
+        return AckTypeAck
+    })
+    must(t, err)
+
+    must(t, PublishJSON(connection, "topic", "moves.bob", "move"))
+    var handling trace.SpanContext
+    select {
+    case handling = <-logged:
+    case <-time.After(testTimeout): t.Fatal("timed out waiting for the log")
+    }
+    // Closing waits for the handlers to finish, and their spans to end.
+    must(t, moves.Close())
+    must(t, logs.Close())
+
+    spans := exporter.GetSpans()
+    byName := map[string][]tracetest.SpanStub {}
+    for _, span := range spans {
+        byName[span.Name] = append(byName[span.Name], span)
+    }
+    if len(spans) != 4 || len(byName["publish topic"]) != 2 || len(byName["process moves"]) != 1 || len(byName["process logs"]) != 1 {
+        names := []string {}
+        for _, span := range spans { names = append(names, span.Name) }
+        t.Fatalf("recorded spans %v, want two publishes and a process span for each queue", names)
+    }
+    publishMove, publishLog := byName["publish topic"][0], byName["publish topic"][1]
+    if publishLog.Parent.SpanID() == (trace.SpanID {}) {
+        publishMove, publishLog = publishLog, publishMove
+    }
+    processMove, processLog := byName["process moves"][0], byName["process logs"][0]
+
+    traceID := publishMove.SpanContext.TraceID()
+    for _, span := range spans {
+        if span.SpanContext.TraceID() != traceID {
+            t.Errorf("%v is in trace %v, want %v", span.Name, span.SpanContext.TraceID(), traceID)
+        }
+    }
+    if handling.TraceID() != traceID || handling.SpanID() != processLog.SpanContext.SpanID() {
+        t.Errorf("Delivery.Context carries span %v, want the log's process span %v", handling.SpanID(), processLog.SpanContext.SpanID())
+    }
+    for _, link := range []struct {
+        child, parent tracetest.SpanStub
+    }{
+        { processMove, publishMove },
+        { publishLog, processMove },
+        { processLog, publishLog },
+    } {
+        if link.child.Parent.SpanID() != link.parent.SpanContext.SpanID() {
+            t.Errorf("%v's parent is %v, want %v's span %v", link.child.Name, link.child.Parent.SpanID(), link.parent.Name, link.parent.SpanContext.SpanID())
+        }
+    }
+    for _, span := range []tracetest.SpanStub { publishMove, publishLog } {
+        if span.SpanKind != trace.SpanKindProducer { t.Errorf("%v is a %v span, want producer", span.Name, span.SpanKind) }
+    }
+    for _, span := range []tracetest.SpanStub { processMove, processLog } {
+        if span.SpanKind != trace.SpanKindConsumer { t.Errorf("%v is a %v span, want consumer", span.Name, span.SpanKind) }
+    }
+}
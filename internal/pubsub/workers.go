@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+    "hash/fnv"
+    "sync"
+    amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Each worker gets this many unacked messages from the broker, so a
+// subscription with n workers has a prefetch of n * prefetchPerWorker.
+const prefetchPerWorker = 10
+
+type subscribeOptions struct {
+    workers int
+    keyOrdering bool
+    singleActiveConsumer bool
+    retry *retryPolicy
+    onDecodeError func(amqp.Delivery, error)
+    middlewares []Middleware
+}
+
+type SubscribeOption func(*subscribeOptions)
+
+// WithWorkers handles up to n messages at the same time.
+func WithWorkers(n int) SubscribeOption {
+    return func(options *subscribeOptions) {
+        if n > 0 { options.workers = n }
+    }
+}
+
+// WithKeyOrdering sends every message with the same routing key to the same
+// worker, so messages from one player are handled in the order they arrived
+// while other players' messages are handled in parallel.
+func WithKeyOrdering() SubscribeOption {
+    return func(options *subscribeOptions) {
+        options.keyOrdering = true
+    }
+}
+
+// WithSingleActiveConsumer declares the queue with SingleActiveConsumer, so
+// of all the processes subscribing to it only one gets messages, and another
+// takes over if it goes away.
+func WithSingleActiveConsumer() SubscribeOption {
+    return func(options *subscribeOptions) {
+        options.singleActiveConsumer = true
+    }
+}
+
+func newSubscribeOptions(opts []SubscribeOption) subscribeOptions {
+    options := subscribeOptions { workers: 1 }
+    for _, opt := range opts {
+        opt(&options)
+    }
+    return options
+}
+
+func (options subscribeOptions) prefetch() int {
+    return options.workers * prefetchPerWorker
+}
+
+func workerFor(key string, workers int) int {
+    hash := fnv.New32a()
+    hash.Write([]byte(key))
+    return int(hash.Sum32() % uint32(workers))
+}
+
+// runWorkers runs work on options.workers goroutines and returns once the
+// delivery channel is closed and every worker has finished.
+func runWorkers(
+    deliveryChannel <-chan amqp.Delivery,
+    options subscribeOptions,
+    work func(<-chan amqp.Delivery),
+) {
+    var wg sync.WaitGroup
+    if !options.keyOrdering {
+        for range options.workers {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                work(deliveryChannel)
+            }()
+        }
+        wg.Wait()
+        return
+    }
+
+    workerChannels := make([]chan amqp.Delivery, options.workers)
+    for i := range workerChannels {
+        workerChannels[i] = make(chan amqp.Delivery)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            work(workerChannels[i])
+        }()
+    }
+
+    for delivery := range deliveryChannel {
+        restoreRoute(&delivery)
+        workerChannels[workerFor(delivery.RoutingKey, options.workers)] <- delivery
+    }
+    for _, workerChannel := range workerChannels {
+        close(workerChannel)
+    }
+    wg.Wait()
+}
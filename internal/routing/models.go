@@ -6,6 +6,16 @@ type PlayingState struct {
 	IsPaused bool
 }
 
+// SnapshotRequest asks a player for a snapshot of their units.
+type SnapshotRequest struct {
+	Requester string
+}
+
+// ScenarioRequest asks the server for the scenario being played.
+type ScenarioRequest struct {
+	Username string
+}
+
 type GameLog struct {
 	CurrentTime time.Time
 	Message     string
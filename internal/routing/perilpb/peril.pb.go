@@ -0,0 +1,525 @@
+// Protobuf schema for the messages Peril sends over peril_direct and
+// peril_topic with content type application/x-protobuf. The Go types in
+// perilpb are generated from this file with go generate, and converted to
+// and from the game's types in internal/routing/proto.go and
+// internal/gamelogic/proto.go.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: peril.proto
+
+package perilpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PlayingState struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	IsPaused      bool                   `protobuf:"varint,1,opt,name=is_paused,json=isPaused,proto3" json:"is_paused,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PlayingState) Reset() {
+	*x = PlayingState{}
+	mi := &file_peril_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PlayingState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PlayingState) ProtoMessage() {}
+
+func (x *PlayingState) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PlayingState.ProtoReflect.Descriptor instead.
+func (*PlayingState) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PlayingState) GetIsPaused() bool {
+	if x != nil {
+		return x.IsPaused
+	}
+	return false
+}
+
+type GameLog struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CurrentTime   *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=current_time,json=currentTime,proto3" json:"current_time,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Username      string                 `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GameLog) Reset() {
+	*x = GameLog{}
+	mi := &file_peril_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GameLog) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GameLog) ProtoMessage() {}
+
+func (x *GameLog) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GameLog.ProtoReflect.Descriptor instead.
+func (*GameLog) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GameLog) GetCurrentTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CurrentTime
+	}
+	return nil
+}
+
+func (x *GameLog) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *GameLog) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+// A unit's id is only unique among its owner's units.
+type Unit struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Rank          string                 `protobuf:"bytes,2,opt,name=rank,proto3" json:"rank,omitempty"`
+	Location      string                 `protobuf:"bytes,3,opt,name=location,proto3" json:"location,omitempty"`
+	Owner         string                 `protobuf:"bytes,4,opt,name=owner,proto3" json:"owner,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Unit) Reset() {
+	*x = Unit{}
+	mi := &file_peril_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Unit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Unit) ProtoMessage() {}
+
+func (x *Unit) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Unit.ProtoReflect.Descriptor instead.
+func (*Unit) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Unit) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Unit) GetRank() string {
+	if x != nil {
+		return x.Rank
+	}
+	return ""
+}
+
+func (x *Unit) GetLocation() string {
+	if x != nil {
+		return x.Location
+	}
+	return ""
+}
+
+func (x *Unit) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+type Player struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Units         map[int64]*Unit        `protobuf:"bytes,2,rep,name=units,proto3" json:"units,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	NextUnitId    int64                  `protobuf:"varint,3,opt,name=next_unit_id,json=nextUnitId,proto3" json:"next_unit_id,omitempty"`
+	Funds         int64                  `protobuf:"varint,4,opt,name=funds,proto3" json:"funds,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Player) Reset() {
+	*x = Player{}
+	mi := &file_peril_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Player) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Player) ProtoMessage() {}
+
+func (x *Player) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Player.ProtoReflect.Descriptor instead.
+func (*Player) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Player) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *Player) GetUnits() map[int64]*Unit {
+	if x != nil {
+		return x.Units
+	}
+	return nil
+}
+
+func (x *Player) GetNextUnitId() int64 {
+	if x != nil {
+		return x.NextUnitId
+	}
+	return 0
+}
+
+func (x *Player) GetFunds() int64 {
+	if x != nil {
+		return x.Funds
+	}
+	return 0
+}
+
+type ArmyMove struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Player        *Player                `protobuf:"bytes,1,opt,name=player,proto3" json:"player,omitempty"`
+	Units         []*Unit                `protobuf:"bytes,2,rep,name=units,proto3" json:"units,omitempty"`
+	ToLocation    string                 `protobuf:"bytes,3,opt,name=to_location,json=toLocation,proto3" json:"to_location,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ArmyMove) Reset() {
+	*x = ArmyMove{}
+	mi := &file_peril_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ArmyMove) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ArmyMove) ProtoMessage() {}
+
+func (x *ArmyMove) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ArmyMove.ProtoReflect.Descriptor instead.
+func (*ArmyMove) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ArmyMove) GetPlayer() *Player {
+	if x != nil {
+		return x.Player
+	}
+	return nil
+}
+
+func (x *ArmyMove) GetUnits() []*Unit {
+	if x != nil {
+		return x.Units
+	}
+	return nil
+}
+
+func (x *ArmyMove) GetToLocation() string {
+	if x != nil {
+		return x.ToLocation
+	}
+	return ""
+}
+
+// Winner and loser are empty if the war was a draw.
+type WarResult struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Attacker       string                 `protobuf:"bytes,1,opt,name=attacker,proto3" json:"attacker,omitempty"`
+	Defender       string                 `protobuf:"bytes,2,opt,name=defender,proto3" json:"defender,omitempty"`
+	Winner         string                 `protobuf:"bytes,3,opt,name=winner,proto3" json:"winner,omitempty"`
+	Loser          string                 `protobuf:"bytes,4,opt,name=loser,proto3" json:"loser,omitempty"`
+	Location       string                 `protobuf:"bytes,5,opt,name=location,proto3" json:"location,omitempty"`
+	AttackerLosses []*Unit                `protobuf:"bytes,6,rep,name=attacker_losses,json=attackerLosses,proto3" json:"attacker_losses,omitempty"`
+	DefenderLosses []*Unit                `protobuf:"bytes,7,rep,name=defender_losses,json=defenderLosses,proto3" json:"defender_losses,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *WarResult) Reset() {
+	*x = WarResult{}
+	mi := &file_peril_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WarResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WarResult) ProtoMessage() {}
+
+func (x *WarResult) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WarResult.ProtoReflect.Descriptor instead.
+func (*WarResult) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *WarResult) GetAttacker() string {
+	if x != nil {
+		return x.Attacker
+	}
+	return ""
+}
+
+func (x *WarResult) GetDefender() string {
+	if x != nil {
+		return x.Defender
+	}
+	return ""
+}
+
+func (x *WarResult) GetWinner() string {
+	if x != nil {
+		return x.Winner
+	}
+	return ""
+}
+
+func (x *WarResult) GetLoser() string {
+	if x != nil {
+		return x.Loser
+	}
+	return ""
+}
+
+func (x *WarResult) GetLocation() string {
+	if x != nil {
+		return x.Location
+	}
+	return ""
+}
+
+func (x *WarResult) GetAttackerLosses() []*Unit {
+	if x != nil {
+		return x.AttackerLosses
+	}
+	return nil
+}
+
+func (x *WarResult) GetDefenderLosses() []*Unit {
+	if x != nil {
+		return x.DefenderLosses
+	}
+	return nil
+}
+
+var File_peril_proto protoreflect.FileDescriptor
+
+const file_peril_proto_rawDesc = "" +
+	"\n" +
+	"\vperil.proto\x12\x05peril\x1a\x1fgoogle/protobuf/timestamp.proto\"+\n" +
+	"\fPlayingState\x12\x1b\n" +
+	"\tis_paused\x18\x01 \x01(\bR\bisPaused\"~\n" +
+	"\aGameLog\x12=\n" +
+	"\fcurrent_time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\vcurrentTime\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x1a\n" +
+	"\busername\x18\x03 \x01(\tR\busername\"\\\n" +
+	"\x04Unit\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04rank\x18\x02 \x01(\tR\x04rank\x12\x1a\n" +
+	"\blocation\x18\x03 \x01(\tR\blocation\x12\x14\n" +
+	"\x05owner\x18\x04 \x01(\tR\x05owner\"\xd3\x01\n" +
+	"\x06Player\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12.\n" +
+	"\x05units\x18\x02 \x03(\v2\x18.peril.Player.UnitsEntryR\x05units\x12 \n" +
+	"\fnext_unit_id\x18\x03 \x01(\x03R\n" +
+	"nextUnitId\x12\x14\n" +
+	"\x05funds\x18\x04 \x01(\x03R\x05funds\x1aE\n" +
+	"\n" +
+	"UnitsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\x03R\x03key\x12!\n" +
+	"\x05value\x18\x02 \x01(\v2\v.peril.UnitR\x05value:\x028\x01\"u\n" +
+	"\bArmyMove\x12%\n" +
+	"\x06player\x18\x01 \x01(\v2\r.peril.PlayerR\x06player\x12!\n" +
+	"\x05units\x18\x02 \x03(\v2\v.peril.UnitR\x05units\x12\x1f\n" +
+	"\vto_location\x18\x03 \x01(\tR\n" +
+	"toLocation\"\xf9\x01\n" +
+	"\tWarResult\x12\x1a\n" +
+	"\battacker\x18\x01 \x01(\tR\battacker\x12\x1a\n" +
+	"\bdefender\x18\x02 \x01(\tR\bdefender\x12\x16\n" +
+	"\x06winner\x18\x03 \x01(\tR\x06winner\x12\x14\n" +
+	"\x05loser\x18\x04 \x01(\tR\x05loser\x12\x1a\n" +
+	"\blocation\x18\x05 \x01(\tR\blocation\x124\n" +
+	"\x0fattacker_losses\x18\x06 \x03(\v2\v.peril.UnitR\x0eattackerLosses\x124\n" +
+	"\x0fdefender_losses\x18\a \x03(\v2\v.peril.UnitR\x0edefenderLossesBFZDgithub.com/bootdotdev/learn-pub-sub-starter/internal/routing/perilpbb\x06proto3"
+
+var (
+	file_peril_proto_rawDescOnce sync.Once
+	file_peril_proto_rawDescData []byte
+)
+
+func file_peril_proto_rawDescGZIP() []byte {
+	file_peril_proto_rawDescOnce.Do(func() {
+		file_peril_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_peril_proto_rawDesc), len(file_peril_proto_rawDesc)))
+	})
+	return file_peril_proto_rawDescData
+}
+
+var file_peril_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_peril_proto_goTypes = []any{
+	(*PlayingState)(nil),          // 0: peril.PlayingState
+	(*GameLog)(nil),               // 1: peril.GameLog
+	(*Unit)(nil),                  // 2: peril.Unit
+	(*Player)(nil),                // 3: peril.Player
+	(*ArmyMove)(nil),              // 4: peril.ArmyMove
+	(*WarResult)(nil),             // 5: peril.WarResult
+	nil,                           // 6: peril.Player.UnitsEntry
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_peril_proto_depIdxs = []int32{
+	7, // 0: peril.GameLog.current_time:type_name -> google.protobuf.Timestamp
+	6, // 1: peril.Player.units:type_name -> peril.Player.UnitsEntry
+	3, // 2: peril.ArmyMove.player:type_name -> peril.Player
+	2, // 3: peril.ArmyMove.units:type_name -> peril.Unit
+	2, // 4: peril.WarResult.attacker_losses:type_name -> peril.Unit
+	2, // 5: peril.WarResult.defender_losses:type_name -> peril.Unit
+	2, // 6: peril.Player.UnitsEntry.value:type_name -> peril.Unit
+	7, // [7:7] is the sub-list for method output_type
+	7, // [7:7] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_peril_proto_init() }
+func file_peril_proto_init() {
+	if File_peril_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_peril_proto_rawDesc), len(file_peril_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_peril_proto_goTypes,
+		DependencyIndexes: file_peril_proto_depIdxs,
+		MessageInfos:      file_peril_proto_msgTypes,
+	}.Build()
+	File_peril_proto = out.File
+	file_peril_proto_goTypes = nil
+	file_peril_proto_depIdxs = nil
+}
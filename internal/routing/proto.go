@@ -0,0 +1,42 @@
+package routing
+
+//go:generate protoc --go_out=perilpb --go_opt=paths=source_relative peril.proto
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/perilpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (ps PlayingState) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&perilpb.PlayingState{IsPaused: ps.IsPaused})
+}
+
+func (ps *PlayingState) UnmarshalProto(b []byte) error {
+	var m perilpb.PlayingState
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*ps = PlayingState{IsPaused: m.IsPaused}
+	return nil
+}
+
+func (gl GameLog) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&perilpb.GameLog{
+		CurrentTime: timestamppb.New(gl.CurrentTime),
+		Message:     gl.Message,
+		Username:    gl.Username,
+	})
+}
+
+func (gl *GameLog) UnmarshalProto(b []byte) error {
+	var m perilpb.GameLog
+	if err := proto.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*gl = GameLog{Message: m.Message, Username: m.Username}
+	if m.CurrentTime != nil {
+		gl.CurrentTime = m.CurrentTime.AsTime()
+	}
+	return nil
+}
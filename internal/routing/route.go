@@ -0,0 +1,174 @@
+package routing
+
+import (
+	"context"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// Route ties a message type to the exchange it is published on, the codec
+// it is encoded with, its routing keys and the queues it is consumed from.
+// Publishing through a Route makes using the wrong type for a message, or
+// a codec that can't encode it, a compile error instead of a runtime one.
+type Route[T any] struct {
+	Exchange string
+	Codec    pubsub.CodecFor[T]
+	// Key is the routing key of a message about subject, usually the
+	// username of the player who sent it.
+	Key func(subject string) string
+	// Pattern is the binding key of subject's queue.
+	Pattern func(subject string) string
+	// Queue names the queue subject consumes the route from.
+	Queue     func(subject string) string
+	QueueType pubsub.QueueType
+	// SingleActiveConsumer queues deliver to one subscriber at a time.
+	SingleActiveConsumer bool
+}
+
+// Fixed names the same queue, or binds the same pattern, for every subject.
+func Fixed(name string) func(string) string {
+	return func(string) string { return name }
+}
+
+var PauseRoute = Route[PlayingState]{
+	Exchange:  ExchangePerilDirect,
+	Codec:     pubsub.JSON[PlayingState](),
+	Key:       Fixed(PauseKey),
+	Pattern:   Fixed(PauseKey),
+	Queue:     PauseQueue,
+	QueueType: pubsub.TransientQueue,
+}
+
+var GameLogRoute = Route[GameLog]{
+	Exchange:  ExchangePerilTopic,
+	Codec:     pubsub.JSON[GameLog](),
+	Key:       GameLogKey,
+	Pattern:   Fixed(AllKeys(GameLogSlug)),
+	Queue:     Fixed(GameLogQueue),
+	QueueType: pubsub.DurableQueue,
+}
+
+// ScenarioRoute carries clients' requests for the scenario, which one server
+// at a time answers.
+var ScenarioRoute = Route[ScenarioRequest]{
+	Exchange:             ExchangePerilDirect,
+	Codec:                pubsub.JSON[ScenarioRequest](),
+	Key:                  Fixed(ScenarioKey),
+	Pattern:              Fixed(ScenarioKey),
+	Queue:                Fixed(ScenarioQueue),
+	QueueType:            pubsub.DurableQueue,
+	SingleActiveConsumer: true,
+}
+
+// SnapshotRoute carries requests for a player's units to that player.
+var SnapshotRoute = Route[SnapshotRequest]{
+	Exchange:  ExchangePerilDirect,
+	Codec:     pubsub.JSON[SnapshotRequest](),
+	Key:       SnapshotKey,
+	Pattern:   SnapshotKey,
+	Queue:     SnapshotQueue,
+	QueueType: pubsub.TransientQueue,
+}
+
+// QueueSpec describes subject's queue for the route.
+func (r Route[T]) QueueSpec(subject string) pubsub.QueueSpec {
+	spec := pubsub.NewQueueSpec(r.Queue(subject), r.QueueType)
+	spec.SingleActiveConsumer = r.SingleActiveConsumer
+	return spec
+}
+
+// Binding binds subject's queue for the route to its exchange.
+func (r Route[T]) Binding(subject string) pubsub.BindingSpec {
+	return pubsub.BindingSpec{Queue: r.Queue(subject), Exchange: r.Exchange, Key: r.Pattern(subject)}
+}
+
+// Queued is a route of any message type, as far as its queues go.
+type Queued interface {
+	QueueSpec(subject string) pubsub.QueueSpec
+	Binding(subject string) pubsub.BindingSpec
+}
+
+// QueueTopology describes subject's queues for routes and their bindings.
+func QueueTopology(subject string, routes ...Queued) pubsub.Topology {
+	var topology pubsub.Topology
+	for _, route := range routes {
+		topology.Queues = append(topology.Queues, route.QueueSpec(subject))
+		topology.Bindings = append(topology.Bindings, route.Binding(subject))
+	}
+	return topology
+}
+
+// subscribeOptions adds the options the route's queues are declared with.
+func (r Route[T]) subscribeOptions(opts []pubsub.SubscribeOption) []pubsub.SubscribeOption {
+	if r.SingleActiveConsumer {
+		return append(opts[:len(opts):len(opts)], pubsub.WithSingleActiveConsumer())
+	}
+	return opts
+}
+
+// Publish publishes a message about subject on route.
+func Publish[T any](ctx context.Context, publisher pubsub.Publisher, route Route[T], subject string, val T) error {
+	return pubsub.PublishContext(ctx, publisher, route.Codec.Name(), route.Exchange, route.Key(subject), val)
+}
+
+// OutboxMessage encodes a message about subject on route, to be committed
+// to an Outbox.
+func OutboxMessage[T any](route Route[T], subject string, val T) (pubsub.OutboxMessage, error) {
+	return pubsub.NewOutboxMessage(route.Codec.Name(), route.Exchange, route.Key(subject), val)
+}
+
+// Subscribe consumes route from subject's queue.
+func Subscribe[T any](
+	ctx context.Context,
+	subscriber pubsub.Subscriber,
+	route Route[T],
+	subject string,
+	handler func(T) pubsub.AckType,
+	opts ...pubsub.SubscribeOption,
+) (*pubsub.Subscription, error) {
+	return pubsub.Subscribe(ctx, subscriber, route.Exchange, route.Queue(subject), route.Pattern(subject), route.QueueType, handler, route.subscribeOptions(opts)...)
+}
+
+// SubscribeDelivery is like Subscribe, but hands the handler the message's
+// metadata along with its body.
+func SubscribeDelivery[T any](
+	ctx context.Context,
+	subscriber pubsub.Subscriber,
+	route Route[T],
+	subject string,
+	handler func(pubsub.Delivery[T]) pubsub.AckType,
+	opts ...pubsub.SubscribeOption,
+) (*pubsub.Subscription, error) {
+	return pubsub.SubscribeDelivery(ctx, subscriber, route.Exchange, route.Queue(subject), route.Pattern(subject), route.QueueType, handler, route.subscribeOptions(opts)...)
+}
+
+// NewBatcher batches messages published on route. Add them with the route's
+// Key for their subject.
+func NewBatcher[T any](publisher pubsub.Publisher, route Route[T], opts ...pubsub.BatchOption) (*pubsub.Batcher[T], error) {
+	return pubsub.NewBatcher[T](publisher, route.Codec.Name(), route.Exchange, opts...)
+}
+
+// SubscribeBatch consumes route from subject's queue a batch at a time.
+func SubscribeBatch[T any](
+	ctx context.Context,
+	subscriber pubsub.Subscriber,
+	route Route[T],
+	subject string,
+	handler func([]T) pubsub.AckType,
+	opts ...pubsub.SubscribeOption,
+) (*pubsub.Subscription, error) {
+	return pubsub.SubscribeBatch(ctx, subscriber, route.Exchange, route.Queue(subject), route.Pattern(subject), route.QueueType, handler, route.subscribeOptions(opts)...)
+}
+
+// Serve answers requests on route from subject's queue with handler.
+func Serve[Req, Resp any](
+	ctx context.Context,
+	subscriber pubsub.Subscriber,
+	publisher pubsub.Publisher,
+	route Route[Req],
+	subject string,
+	handler func(context.Context, Req) (Resp, error),
+	opts ...pubsub.SubscribeOption,
+) (*pubsub.Subscription, error) {
+	return pubsub.Serve(ctx, subscriber, publisher, route.Exchange, route.Queue(subject), route.Pattern(subject), route.QueueType, handler, route.subscribeOptions(opts)...)
+}
@@ -3,14 +3,33 @@ package routing
 const (
 	ArmyMovesPrefix = "army_moves"
 
-	WarRecognitionsPrefix = "war"
+	WarResultsPrefix = "war_results"
 
 	PauseKey = "pause"
 
+	ScenarioKey = "scenario"
+
 	GameLogSlug = "game_logs"
+
+	SnapshotPrefix = "snapshot"
+
+	IntentsPrefix = "intents"
+
+	PlayerStatesPrefix = "player_states"
+
+	IntentRejectionsPrefix = "intent_rejections"
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
 )
+
+// Message metadata stamped by the client and server.
+const (
+	AppIDClient = "peril_client"
+	AppIDServer = "peril_server"
+
+	HeaderUsername = "x-peril-username"
+	HeaderServerID = "x-peril-server-id"
+)
@@ -0,0 +1,83 @@
+package routing
+
+// Queues shared by every client or server.
+const (
+	GameLogQueue  = GameLogSlug
+	IntentQueue   = IntentsPrefix
+	ScenarioQueue = ScenarioKey
+)
+
+// PauseQueue is the queue a player receives pause and resume messages on.
+func PauseQueue(username string) string {
+	return PauseKey + "." + username
+}
+
+// ArmyMovesQueue is the queue a player receives other players' moves on.
+func ArmyMovesQueue(username string) string {
+	return ArmyMovesPrefix + "." + username
+}
+
+// SnapshotQueue is the queue a player serves snapshot requests from.
+func SnapshotQueue(username string) string {
+	return SnapshotPrefix + "." + username
+}
+
+// WarResultsQueue is the queue a player, or a server, receives the results
+// of wars on.
+func WarResultsQueue(subject string) string {
+	return WarResultsPrefix + "." + subject
+}
+
+// PlayerStatesQueue is the queue a player, or a server, receives the
+// server's copy of players' states on.
+func PlayerStatesQueue(subject string) string {
+	return PlayerStatesPrefix + "." + subject
+}
+
+// IntentRejectionsQueue is the queue a player hears about their rejected
+// intents on.
+func IntentRejectionsQueue(username string) string {
+	return IntentRejectionsPrefix + "." + username
+}
+
+// SnapshotKey is the routing key of snapshot requests for a player.
+func SnapshotKey(username string) string {
+	return SnapshotPrefix + "." + username
+}
+
+// ArmyMovesKey is the routing key of a player's moves.
+func ArmyMovesKey(username string) string {
+	return ArmyMovesPrefix + "." + username
+}
+
+// WarResultKey is the routing key of the result of a war fought by a
+// player.
+func WarResultKey(username string) string {
+	return WarResultsPrefix + "." + username
+}
+
+// IntentKey is the routing key of a player's intents.
+func IntentKey(username string) string {
+	return IntentsPrefix + "." + username
+}
+
+// PlayerStateKey is the routing key of the server's copy of a player's
+// state.
+func PlayerStateKey(username string) string {
+	return PlayerStatesPrefix + "." + username
+}
+
+// IntentRejectionKey is the routing key of a player's rejected intents.
+func IntentRejectionKey(username string) string {
+	return IntentRejectionsPrefix + "." + username
+}
+
+// GameLogKey is the routing key of a game log about a player.
+func GameLogKey(username string) string {
+	return GameLogSlug + "." + username
+}
+
+// AllKeys matches every routing key starting with prefix and a dot.
+func AllKeys(prefix string) string {
+	return prefix + ".*"
+}